@@ -0,0 +1,133 @@
+package netio
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	Unauthorized = errors.New("unauthorized")
+	InvalidToken = errors.New("invalid token")
+	ExpiredToken = errors.New("expired token")
+)
+
+// Principal is the identity of an authenticated client.
+type Principal struct {
+	// Id identifies the client, the subject of a token for example.
+	Id string `json:"id"`
+	// Claims are the other attributes of the client.
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Authenticator returns the principal of a handshake request, or an error to
+// reject it.
+type Authenticator func(r *http.Request) (Principal, error)
+
+// SetAuthenticator authenticates the handshakes with f. The principal is kept
+// by the connection, see NameSpace.Principal. Default is nil, the handshakes are
+// not authenticated.
+func (s *Server) SetAuthenticator(f Authenticator) {
+	s.config.Authenticator = f
+}
+
+// BearerToken returns the token of the Authorization header, or of the
+// access_token or token query parameter, which socket.io 0.9 clients can only
+// use.
+func BearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	query := r.URL.Query()
+	if token := query.Get("access_token"); token != "" {
+		return token
+	}
+	return query.Get("token")
+}
+
+// BearerAuthenticator authenticates the bearer token with verify.
+func BearerAuthenticator(verify func(token string) (Principal, error)) Authenticator {
+	return func(r *http.Request) (Principal, error) {
+		token := BearerToken(r)
+		if token == "" {
+			return Principal{}, Unauthorized
+		}
+		return verify(token)
+	}
+}
+
+// JWTAuthenticator authenticates the bearer token as a JWT signed with
+// HS256 by secret. The principal is the subject, with the claims.
+func JWTAuthenticator(secret []byte) Authenticator {
+	return BearerAuthenticator(func(token string) (Principal, error) {
+		claims, err := ParseJWT(token, secret)
+		if err != nil {
+			return Principal{}, err
+		}
+		sub, _ := claims["sub"].(string)
+		return Principal{Id: sub, Claims: claims}, nil
+	})
+}
+
+// CookieAuthenticator authenticates the cookie name with lookup, the session of
+// a web application for example.
+func CookieAuthenticator(name string, lookup func(value string) (Principal, error)) Authenticator {
+	return func(r *http.Request) (Principal, error) {
+		cookie, err := r.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return Principal{}, Unauthorized
+		}
+		return lookup(cookie.Value)
+	}
+}
+
+// ParseJWT verifies the HS256 signature, and the exp and nbf claims, of token,
+// and returns its claims.
+func ParseJWT(token string, secret []byte) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, InvalidToken
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+	}{}
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, InvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, InvalidToken
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, InvalidToken
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, InvalidToken
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		return nil, ExpiredToken
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, InvalidToken
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,78 @@
+package netio
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func signJWT(header, claims string, secret []byte) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthenticator(t *testing.T) {
+	srv, client := newTestServer(t)
+	secret := []byte("secret")
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	valid := signJWT(hs256, fmt.Sprintf(`{"sub":"alice","role":"admin","exp":%d}`, time.Now().Add(time.Hour).Unix()), secret)
+
+	claims, err := ParseJWT(valid, secret)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "admin", claims["role"])
+	_, err = ParseJWT(valid, []byte("other"))
+	assert.Equal(t, InvalidToken, err)
+	_, err = ParseJWT(signJWT(`{"alg":"none"}`, `{"sub":"alice"}`, secret), secret)
+	assert.Equal(t, InvalidToken, err)
+	_, err = ParseJWT(signJWT(hs256, `{"sub":"alice","exp":1}`, secret), secret)
+	assert.Equal(t, ExpiredToken, err)
+	_, err = ParseJWT("a.b", secret)
+	assert.Equal(t, InvalidToken, err)
+
+	r, _ := http.NewRequest("GET", "/socket.io/1/?token="+valid, strings.NewReader(""))
+	principal, err := JWTAuthenticator(secret)(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "alice", principal.Id)
+	req, _ := srv.checkRequest(r)
+	assert.Equal(t, valid, req.Query.Get("token"))
+	r.Header.Set("Authorization", "Bearer "+valid+"x")
+	_, err = JWTAuthenticator(secret)(r)
+	assert.Equal(t, InvalidToken, err)
+
+	sessions := CookieAuthenticator("session", func(value string) (Principal, error) {
+		if value != "s1" {
+			return Principal{}, Unauthorized
+		}
+		return Principal{Id: "bob"}, nil
+	})
+	r, _ = http.NewRequest("GET", "/socket.io/1/", strings.NewReader(""))
+	_, err = sessions(r)
+	assert.Equal(t, Unauthorized, err)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+	principal, _ = sessions(r)
+	assert.Equal(t, "bob", principal.Id)
+
+	srv.SetAuthenticator(JWTAuthenticator(secret))
+	events := 0
+	srv.SetSecurityHandler(func(e SecurityEvent) {
+		events++
+	})
+	w := client.get("/socket.io/1/?token=forged")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, 1, events)
+	assert.Equal(t, 0, srv.SessionCount())
+
+	w = client.get("/socket.io/1/?token=" + valid)
+	conn := srv.GetSessionManager().Get(strings.Split(w.Body.String(), ":")[0])
+	defer conn.Close()
+	assert.Equal(t, "alice", conn.Of("").Principal().Id)
+}
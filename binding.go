@@ -0,0 +1,189 @@
+package netio
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+// SessionBinding binds a session to the client which created it. The requests
+// of the session from another client are rejected with 403.
+type SessionBinding struct {
+	// IP binds to the client ip, see Server.ClientAddr.
+	IP bool
+	// IPv4Bits and IPv6Bits are the length of the prefix of the subnet the ip
+	// is bound to. 0 is the whole address.
+	IPv4Bits int
+	IPv6Bits int
+	// UserAgent binds to the User-Agent header.
+	UserAgent bool
+	// Cookie binds to a cookie, named by SetCookie, holding a random token of
+	// the browser signed with Secret. The token is issued by the first
+	// handshake and reused by the next ones, so the sessions of the tabs of a
+	// browser are all bound to it. A random secret is used if Secret is empty.
+	Cookie bool
+	Secret []byte
+}
+
+// SecurityEvent is a request rejected for the security of a session.
+type SecurityEvent struct {
+	Sid     string
+	Reason  string
+	Request *http.Request
+}
+
+const (
+	SecurityIP        = "ip"
+	SecurityUserAgent = "user-agent"
+	SecurityCookie    = "cookie"
+	SecurityAuth      = "auth"
+)
+
+// fingerprint is the client a session is bound to.
+type fingerprint struct {
+	binding   *SessionBinding
+	address   string
+	subnet    *net.IPNet
+	userAgent string
+	token     string
+}
+
+// browserTokenBytes is the size of the token of the cookie.
+const browserTokenBytes = 16
+
+// SetSessionBinding sets the binding of the sessions to their client. Default
+// is nil, sessions are not bound.
+func (s *Server) SetSessionBinding(binding *SessionBinding) {
+	if binding != nil && binding.Cookie && len(binding.Secret) == 0 {
+		b := *binding
+		b.Secret = make([]byte, 32)
+		if _, err := rand.Read(b.Secret); err != nil {
+			panic(err)
+		}
+		binding = &b
+	}
+	s.config.SessionBinding = binding
+}
+
+// SetSecurityHandler sets the callback of the rejected requests. Default is nil.
+func (s *Server) SetSecurityHandler(f func(SecurityEvent)) {
+	s.config.SecurityHandler = f
+}
+
+func (s *Server) onSecurityEvent(sid, reason string, r *http.Request) {
+	ip, _ := s.ClientAddr(r)
+	log.Warnf("[%s] %s rejected, %s", sid, ip, reason)
+	if s.config.SecurityHandler != nil {
+		s.config.SecurityHandler(SecurityEvent{Sid: sid, Reason: reason, Request: r})
+	}
+}
+
+// bindSession binds conn to the client of the handshake request r, and sets the
+// cookie.
+func (s *Server) bindSession(conn *serverConn, w http.ResponseWriter, r *http.Request) {
+	binding := s.config.SessionBinding
+	if binding == nil {
+		return
+	}
+	fp := &fingerprint{binding: binding}
+	if binding.IP {
+		fp.address, _ = s.ClientAddr(r)
+		fp.subnet = binding.subnet(fp.address)
+	}
+	if binding.UserAgent {
+		fp.userAgent = r.UserAgent()
+	}
+	conn.fingerprint = fp
+
+	if binding.Cookie {
+		if fp.token = s.browserToken(binding, r); fp.token != "" {
+			return
+		}
+		b := make([]byte, browserTokenBytes)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		fp.token = base64.RawURLEncoding.EncodeToString(b)
+		http.SetCookie(w, &http.Cookie{
+			Name:     s.config.Cookie,
+			Value:    fp.token + "." + binding.sign(fp.token),
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   conn.handshake != nil && conn.handshake.Secure,
+		})
+	}
+}
+
+// browserToken returns the token of the cookie of r, empty if there is none or
+// it is not signed by binding.
+func (s *Server) browserToken(binding *SessionBinding, r *http.Request) string {
+	cookie, err := r.Cookie(s.config.Cookie)
+	if err != nil {
+		return ""
+	}
+	i := strings.LastIndex(cookie.Value, ".")
+	if i < 0 {
+		return ""
+	}
+	token, mac := cookie.Value[:i], cookie.Value[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(binding.sign(token))) {
+		return ""
+	}
+	return token
+}
+
+// checkBinding returns the reason r is not from the client conn is bound to,
+// empty if it is.
+func (s *Server) checkBinding(conn *serverConn, r *http.Request) string {
+	fp := conn.fingerprint
+	if fp == nil {
+		return ""
+	}
+	binding := fp.binding
+	if binding.IP {
+		ip, _ := s.ClientAddr(r)
+		if fp.subnet == nil {
+			if ip != fp.address {
+				return SecurityIP
+			}
+		} else if parsed := net.ParseIP(ip); parsed == nil || !fp.subnet.Contains(parsed) {
+			return SecurityIP
+		}
+	}
+	if binding.UserAgent && r.UserAgent() != fp.userAgent {
+		return SecurityUserAgent
+	}
+	if binding.Cookie && s.browserToken(binding, r) != fp.token {
+		return SecurityCookie
+	}
+	return ""
+}
+
+func (b *SessionBinding) subnet(ip string) *net.IPNet {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		// 无法解析的地址只能完全匹配
+		return nil
+	}
+	bits, size := b.IPv6Bits, 128
+	if ip4 := parsed.To4(); ip4 != nil {
+		parsed, bits, size = ip4, b.IPv4Bits, 32
+	}
+	if bits <= 0 || bits > size {
+		bits = size
+	}
+	mask := net.CIDRMask(bits, size)
+	return &net.IPNet{IP: parsed.Mask(mask), Mask: mask}
+}
+
+func (b *SessionBinding) sign(token string) string {
+	mac := hmac.New(sha256.New, b.Secret)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
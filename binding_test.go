@@ -0,0 +1,98 @@
+package netio
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSessionBinding(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.SetSessionBinding(&SessionBinding{IP: true, IPv4Bits: 24, UserAgent: true, Cookie: true})
+	events := []SecurityEvent{}
+	srv.SetSecurityHandler(func(e SecurityEvent) {
+		events = append(events, e)
+	})
+
+	r, _ := http.NewRequest("GET", "/socket.io/1/", strings.NewReader(""))
+	r.RemoteAddr = "198.51.100.7:5000"
+	r.Header.Set("User-Agent", "browser")
+	w := client.serve(r)
+	sid := strings.Split(w.Body.String(), ":")[0]
+	cookies := w.Result().Cookies()
+	assert.Equal(t, 1, len(cookies))
+	conn := srv.GetSessionManager().Get(sid).(*serverConn)
+	defer conn.Close()
+
+	newRequest := func(remote, ua string, cookie *http.Cookie) *http.Request {
+		r, _ := http.NewRequest("POST", "/socket.io/1/xhr-polling/"+sid, strings.NewReader(""))
+		r.RemoteAddr = remote
+		r.Header.Set("User-Agent", ua)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		return r
+	}
+	assert.Equal(t, "", srv.checkBinding(conn, newRequest("198.51.100.8:6000", "browser", cookies[0])))
+	assert.Equal(t, SecurityIP, srv.checkBinding(conn, newRequest("203.0.113.9:6000", "browser", cookies[0])))
+	assert.Equal(t, SecurityUserAgent, srv.checkBinding(conn, newRequest("198.51.100.7:6000", "curl", cookies[0])))
+	assert.Equal(t, SecurityCookie, srv.checkBinding(conn, newRequest("198.51.100.7:6000", "browser", nil)))
+	forged := &http.Cookie{Name: cookies[0].Name, Value: "forged"}
+	assert.Equal(t, SecurityCookie, srv.checkBinding(conn, newRequest("198.51.100.7:6000", "browser", forged)))
+
+	w = client.serve(newRequest("203.0.113.9:6000", "browser", cookies[0]))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, sid, events[0].Sid)
+	assert.Equal(t, SecurityIP, events[0].Reason)
+}
+
+func TestSessionBindingTabs(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.SetSessionBinding(&SessionBinding{Cookie: true})
+	jar, _ := cookiejar.New(nil)
+	u, _ := url.Parse("http://example.com/")
+	serve := func(path string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "http://example.com"+path, strings.NewReader(""))
+		for _, cookie := range jar.Cookies(u) {
+			r.AddCookie(cookie)
+		}
+		w := client.serve(r)
+		jar.SetCookies(u, w.Result().Cookies())
+		return w
+	}
+
+	// 同一浏览器的两个标签页
+	conns := []*serverConn{}
+	for i := 0; i < 2; i++ {
+		w := serve("/socket.io/1/")
+		assert.Equal(t, i == 0, len(w.Result().Cookies()) == 1)
+		sid := strings.Split(w.Body.String(), ":")[0]
+		conn := srv.GetSessionManager().Get(sid).(*serverConn)
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	assert.Equal(t, 1, len(jar.Cookies(u)))
+	for _, conn := range conns {
+		r, _ := http.NewRequest("GET", "http://example.com/socket.io/1/xhr-polling/"+conn.Id(), nil)
+		for _, cookie := range jar.Cookies(u) {
+			r.AddCookie(cookie)
+		}
+		assert.Equal(t, "", srv.checkBinding(conn, r))
+		assert.Equal(t, http.StatusOK, serve("/socket.io/1/xhr-polling/"+conn.Id()).Code)
+	}
+
+	// 另一个浏览器
+	w := client.get("/socket.io/1/")
+	other := srv.GetSessionManager().Get(strings.Split(w.Body.String(), ":")[0]).(*serverConn)
+	defer other.Close()
+	assert.Equal(t, 1, len(w.Result().Cookies()))
+	r, _ := http.NewRequest("GET", "/socket.io/1/xhr-polling/"+other.Id(), nil)
+	r.AddCookie(jar.Cookies(u)[0])
+	assert.Equal(t, SecurityCookie, srv.checkBinding(other, r))
+}
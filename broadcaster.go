@@ -1,21 +1,99 @@
 package netio
 
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+)
+
 type Broadcaster struct {
 	Namespaces []*NameSpace
+	// Workers is the number of goroutines writing the broadcast.
+	Workers int
+	// Compress asks for the broadcast to be compressed, see
+	// BroadcastOperator.Compress.
+	Compress bool
 }
 
-func (b *Broadcaster) Broadcast(name string, args ...interface{}) {
-	for _, ns := range b.Namespaces {
-		go ns.Emit(name, args...)
+// BroadcastResult counts the namespaces a broadcast was written to, and the
+// ones it could not be written to, disconnected or closed.
+type BroadcastResult struct {
+	Delivered int
+	Failed    int
+}
+
+type broadcastKey struct {
+	proto    protocol
+	endpoint string
+}
+
+// Broadcast emits the event to all the namespaces. The event is encoded once
+// per protocol and endpoint, and the same bytes are written to every connection.
+func (b *Broadcaster) Broadcast(name string, args ...interface{}) BroadcastResult {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return BroadcastResult{Failed: len(b.Namespaces)}
 	}
+	return b.broadcast(name, data)
 }
 
-func (b *Broadcaster) Except(namespace *NameSpace) *Broadcaster {
+// broadcast emits the event with data, the json array of the arguments.
+func (b *Broadcaster) broadcast(name string, data json.RawMessage) BroadcastResult {
+	encoded := make(map[broadcastKey][]byte)
+	packets := make([][]byte, len(b.Namespaces))
 	for i, ns := range b.Namespaces {
-		if ns == namespace {
-			b.Namespaces = append(b.Namespaces[:i], b.Namespaces[i+1:]...)
-			return b
+		key := broadcastKey{ns.getProto(), ns.endpoint}
+		packByte, ok := encoded[key]
+		if !ok {
+			pack := new(eventPacket)
+			pack.endPoint = ns.endpoint
+			pack.name = name
+			pack.args = data
+			packByte = key.proto.encodePacket(ns.endpoint, pack)
+			encoded[key] = packByte
+		}
+		packets[i] = packByte
+	}
+
+	workers := b.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(b.Namespaces) {
+		workers = len(b.Namespaces)
+	}
+
+	var next, delivered, failed int32
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt32(&next, 1)) - 1
+				if i >= len(b.Namespaces) {
+					return
+				}
+				if err := b.Namespaces[i].sendRaw(packets[i], b.Compress); err != nil {
+					atomic.AddInt32(&failed, 1)
+				} else {
+					atomic.AddInt32(&delivered, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return BroadcastResult{Delivered: int(delivered), Failed: int(failed)}
+}
+
+// Except returns a Broadcaster without namespace, b is left unchanged.
+func (b *Broadcaster) Except(namespace *NameSpace) *Broadcaster {
+	ret := &Broadcaster{Namespaces: make([]*NameSpace, 0, len(b.Namespaces)), Workers: b.Workers, Compress: b.Compress}
+	for _, ns := range b.Namespaces {
+		if ns != namespace {
+			ret.Namespaces = append(ret.Namespaces, ns)
 		}
 	}
-	return b
+	return ret
 }
@@ -0,0 +1,50 @@
+package netio
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+type broadcastConn struct {
+	id      string
+	written [][]byte
+}
+
+func (c *broadcastConn) Id() string                { return c.id }
+func (c *broadcastConn) Request() *http.Request    { return nil }
+func (c *broadcastConn) Close() error              { return nil }
+func (c *broadcastConn) Of(name string) *NameSpace { return nil }
+func (c *broadcastConn) Handshake() *Handshake     { return &Handshake{Sid: c.id} }
+func (c *broadcastConn) Data() *DataBag            { return nil }
+func (c *broadcastConn) Principal() Principal      { return Principal{} }
+
+func (c *broadcastConn) Write(p []byte) (int, error) {
+	c.written = append(c.written, p)
+	return len(p), nil
+}
+
+func TestBroadcast(t *testing.T) {
+	conns := []*broadcastConn{}
+	b := &Broadcaster{Workers: 2}
+	for i := 0; i < 5; i++ {
+		conn := &broadcastConn{id: strconv.Itoa(i)}
+		conns = append(conns, conn)
+		ns := NewNameSpace(conn, "/chat", NewEventEmitter())
+		if i == 3 {
+			ns.proto = eioV4
+		}
+		ns.setConnected(i != 4)
+		b.Namespaces = append(b.Namespaces, ns)
+	}
+
+	result := b.Broadcast("news", "hello")
+	assert.Equal(t, BroadcastResult{Delivered: 4, Failed: 1}, result)
+	assert.Equal(t, "5::/chat:{\"name\":\"news\",\"args\":[\"hello\"]}\n", string(conns[0].written[0]))
+	assert.Equal(t, `42/chat,["news","hello"]`, string(conns[3].written[0]))
+	assert.Equal(t, 0, len(conns[4].written))
+	// encoded once for all the 0.9 connections
+	assert.Equal(t, &conns[0].written[0][0], &conns[2].written[0][0])
+}
@@ -0,0 +1,380 @@
+package netio
+
+import (
+	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// ClusterAdapter carries the socket operations between the nodes of a cluster.
+// store.PubSubAdapter adapts a store.PubSubStore.
+type ClusterAdapter interface {
+	Publish(subj string, data []byte) error
+	Subscribe(subj string, cb func(data []byte)) error
+}
+
+// SocketFilter selects the connections of the bulk socket operations.
+type SocketFilter struct {
+	// Endpoint is the namespace of the connections.
+	Endpoint string `json:"endpoint"`
+	// Rooms, if any, selects the connections in any of them.
+	Rooms []string `json:"rooms,omitempty"`
+	// Except excludes the connections of the session ids, or in the rooms.
+	Except []string `json:"except,omitempty"`
+	// Query selects the connections whose handshake query has these values.
+	Query map[string]string `json:"query,omitempty"`
+	// Match selects the connections it returns true for. It only applies on
+	// this node, a filter with Match is not relayed to the cluster.
+	Match func(ns *NameSpace) bool `json:"-"`
+}
+
+// RemoteSocket describes a connection, possibly on another node. It leaves out
+// the headers and the query of the handshake, which may carry credentials.
+type RemoteSocket struct {
+	Id       string   `json:"id"`
+	Endpoint string   `json:"endpoint"`
+	Rooms    []string `json:"rooms"`
+	Node     string   `json:"node"`
+	// Address, Transport and Time are from the handshake of the connection.
+	Address   string `json:"address"`
+	Transport string `json:"transport"`
+	Time      int64  `json:"time"`
+	// Principal is the id of the principal of the connection, see Authenticator.
+	Principal string `json:"principal,omitempty"`
+	// Data is the user data of the connection, see DataBag.
+	Data map[string]interface{} `json:"data"`
+}
+
+const (
+	socketsFetch      = "fetch"
+	socketsDisconnect = "disconnect"
+	socketsJoin       = "join"
+	socketsLeave      = "leave"
+	socketsEmit       = "emit"
+	// socketsHello announces a node joining the cluster.
+	socketsHello = "hello"
+)
+
+type socketsRequest struct {
+	Id     string       `json:"id"`
+	Node   string       `json:"node"`
+	Op     string       `json:"op"`
+	Filter SocketFilter `json:"filter"`
+	Rooms  []string     `json:"rooms,omitempty"`
+	Reason string       `json:"reason,omitempty"`
+	// Event, Args, Volatile and Compress are the broadcast of an emit request.
+	Event    string          `json:"event,omitempty"`
+	Args     json.RawMessage `json:"args,omitempty"`
+	Volatile bool            `json:"volatile,omitempty"`
+	Compress bool            `json:"compress,omitempty"`
+}
+
+type socketsResponse struct {
+	Id      string         `json:"id"`
+	Node    string         `json:"node"`
+	Sockets []RemoteSocket `json:"sockets"`
+}
+
+// cluster relays the socket operations through the ClusterAdapter.
+type cluster struct {
+	adapter ClusterAdapter
+	locker  sync.Mutex
+	pending map[string]chan *socketsResponse
+	// nodes are the other nodes of the cluster, the ones FetchSockets waits for.
+	nodes map[string]bool
+}
+
+func (s *Server) requestsSubject() string {
+	return s.namespace("sockets")
+}
+
+func (s *Server) responsesSubject(node string) string {
+	return s.namespace("sockets." + node)
+}
+
+// SetAdapter relays FetchSockets, DisconnectSockets, SocketsJoin, SocketsLeave
+// and the broadcasts of BroadcastOperator.Emit to the other nodes of the
+// cluster through adapter. Default is nil, the operations only apply on this
+// node.
+func (s *Server) SetAdapter(adapter ClusterAdapter) error {
+	c := &cluster{
+		adapter: adapter,
+		pending: make(map[string]chan *socketsResponse),
+		nodes:   make(map[string]bool),
+	}
+	if err := adapter.Subscribe(s.requestsSubject(), s.onSocketsRequest); err != nil {
+		return err
+	}
+	if err := adapter.Subscribe(s.responsesSubject(s.config.NodeId), c.onResponse); err != nil {
+		return err
+	}
+	s.clusterLocker.Lock()
+	s.cluster = c
+	s.clusterLocker.Unlock()
+	return s.publishSockets(c, &socketsRequest{Node: s.config.NodeId, Op: socketsHello})
+}
+
+// SetNodeId sets the id of this node in the cluster. Default is random.
+func (s *Server) SetNodeId(id string) {
+	s.config.NodeId = id
+}
+
+func (s *Server) getCluster() *cluster {
+	s.clusterLocker.Lock()
+	defer s.clusterLocker.Unlock()
+
+	return s.cluster
+}
+
+// FetchSockets returns the connections selected by filter, on all the nodes.
+// It returns once the known nodes answered, nodes answering later than the
+// cluster timeout are left out and forgotten until they are heard from again.
+func (s *Server) FetchSockets(filter SocketFilter) ([]RemoteSocket, error) {
+	sockets := []RemoteSocket{}
+	for _, ns := range s.matchSockets(filter) {
+		sockets = append(sockets, s.describeSocket(ns))
+	}
+
+	c := s.getCluster()
+	if c == nil || filter.Match != nil {
+		return sockets, nil
+	}
+
+	req := &socketsRequest{Id: newRequestId(), Node: s.config.NodeId, Op: socketsFetch, Filter: filter}
+	responses := make(chan *socketsResponse, 16)
+	c.locker.Lock()
+	c.pending[req.Id] = responses
+	waiting := make(map[string]bool, len(c.nodes))
+	for node := range c.nodes {
+		waiting[node] = true
+	}
+	c.locker.Unlock()
+	defer func() {
+		c.locker.Lock()
+		delete(c.pending, req.Id)
+		c.locker.Unlock()
+	}()
+
+	if err := s.publishSockets(c, req); err != nil {
+		return sockets, err
+	}
+	// with no known node, wait the timeout for the nodes not heard from yet
+	known := len(waiting) > 0
+	timeout := time.After(s.config.ClusterTimeout)
+	for !known || len(waiting) > 0 {
+		select {
+		case resp := <-responses:
+			sockets = append(sockets, resp.Sockets...)
+			delete(waiting, resp.Node)
+		case <-timeout:
+			c.locker.Lock()
+			for node := range waiting {
+				log.Warnf("node %s did not answer, forgotten", node)
+				delete(c.nodes, node)
+			}
+			c.locker.Unlock()
+			return sockets, nil
+		}
+	}
+	return sockets, nil
+}
+
+// DisconnectSockets disconnects the connections selected by filter, on all the
+// nodes. If reason is not empty, it is sent to the clients as an error first.
+// Filtering the default namespace closes the connections.
+func (s *Server) DisconnectSockets(filter SocketFilter, reason string) error {
+	return s.socketsOp(&socketsRequest{Op: socketsDisconnect, Filter: filter, Reason: reason})
+}
+
+// SocketsJoin makes the connections selected by filter join the rooms, on all
+// the nodes.
+func (s *Server) SocketsJoin(filter SocketFilter, rooms ...string) error {
+	return s.socketsOp(&socketsRequest{Op: socketsJoin, Filter: filter, Rooms: rooms})
+}
+
+// SocketsLeave makes the connections selected by filter leave the rooms, on all
+// the nodes.
+func (s *Server) SocketsLeave(filter SocketFilter, rooms ...string) error {
+	return s.socketsOp(&socketsRequest{Op: socketsLeave, Filter: filter, Rooms: rooms})
+}
+
+func (s *Server) socketsOp(req *socketsRequest) error {
+	s.applySockets(req)
+
+	c := s.getCluster()
+	if c == nil || req.Filter.Match != nil {
+		return nil
+	}
+	req.Id = newRequestId()
+	req.Node = s.config.NodeId
+	return s.publishSockets(c, req)
+}
+
+func (s *Server) publishSockets(c *cluster, req *socketsRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return c.adapter.Publish(s.requestsSubject(), data)
+}
+
+// applySockets applies a disconnect, join, leave or emit request on this node.
+func (s *Server) applySockets(req *socketsRequest) {
+	if req.Op == socketsEmit {
+		op := BroadcastOperator{srv: s, endpoint: req.Filter.Endpoint, volatile: req.Volatile, compress: req.Compress}
+		op = op.To(req.Filter.Rooms...).Except(req.Filter.Except...)
+		broadcaster := &Broadcaster{Namespaces: op.Sockets(), Workers: s.config.BroadcastWorkers, Compress: op.compress}
+		broadcaster.broadcast(req.Event, req.Args)
+		return
+	}
+	for _, ns := range s.matchSockets(req.Filter) {
+		switch req.Op {
+		case socketsDisconnect:
+			if req.Reason != "" {
+				packet := new(errorPacket)
+				packet.reason = req.Reason
+				ns.sendPacket(packet)
+			}
+			if ns.Endpoint() == "" {
+				ns.Conn.Close()
+			} else {
+				ns.onDisconnect()
+			}
+		case socketsJoin:
+			ns.Join(req.Rooms...)
+		case socketsLeave:
+			ns.Leave(req.Rooms...)
+		}
+	}
+}
+
+func (s *Server) onSocketsRequest(data []byte) {
+	req := new(socketsRequest)
+	if err := json.Unmarshal(data, req); err != nil {
+		log.Errorf("invalid sockets request %s [%s]", err, string(data))
+		return
+	}
+	if req.Node == s.config.NodeId {
+		return
+	}
+	c := s.getCluster()
+	if c == nil {
+		return
+	}
+	c.seen(req.Node)
+
+	resp := &socketsResponse{Id: req.Id, Node: s.config.NodeId, Sockets: []RemoteSocket{}}
+	switch req.Op {
+	case socketsHello:
+		// answered with no sockets, so the new node knows this one
+	case socketsFetch:
+		for _, ns := range s.matchSockets(req.Filter) {
+			resp.Sockets = append(resp.Sockets, s.describeSocket(ns))
+		}
+	default:
+		s.applySockets(req)
+		return
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Errorf("json.Marshal error %s", err)
+		return
+	}
+	if err := c.adapter.Publish(s.responsesSubject(req.Node), b); err != nil {
+		log.Errorf("publish sockets response error %s", err)
+	}
+}
+
+func (c *cluster) onResponse(data []byte) {
+	resp := new(socketsResponse)
+	if err := json.Unmarshal(data, resp); err != nil {
+		log.Errorf("invalid sockets response %s [%s]", err, string(data))
+		return
+	}
+	c.seen(resp.Node)
+	c.locker.Lock()
+	responses := c.pending[resp.Id]
+	c.locker.Unlock()
+	if responses == nil {
+		return
+	}
+	select {
+	case responses <- resp:
+	default:
+		log.Warnf("sockets response %s dropped", resp.Id)
+	}
+}
+
+// seen records node as a node of the cluster.
+func (c *cluster) seen(node string) {
+	if node == "" {
+		return
+	}
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.nodes[node] = true
+}
+
+// matchSockets returns the connections of this node selected by filter.
+func (s *Server) matchSockets(filter SocketFilter) []*NameSpace {
+	op := BroadcastOperator{srv: s, endpoint: filter.Endpoint}.To(filter.Rooms...).Except(filter.Except...)
+	ret := []*NameSpace{}
+	for _, ns := range op.Sockets() {
+		if !matchQuery(ns, filter.Query) {
+			continue
+		}
+		if filter.Match != nil && !filter.Match(ns) {
+			continue
+		}
+		ret = append(ret, ns)
+	}
+	return ret
+}
+
+func matchQuery(ns *NameSpace, query map[string]string) bool {
+	if len(query) == 0 {
+		return true
+	}
+	handshake := ns.Conn.Handshake()
+	if handshake == nil {
+		return false
+	}
+	for k, v := range query {
+		if handshake.Query.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) describeSocket(ns *NameSpace) RemoteSocket {
+	ret := RemoteSocket{
+		Id:       ns.Id(),
+		Endpoint: ns.Endpoint(),
+		Rooms:    ns.Rooms(),
+		Node:     s.config.NodeId,
+	}
+	if h := ns.Conn.Handshake(); h != nil {
+		ret.Address = h.Address
+		ret.Transport = h.Transport
+		ret.Time = h.Time
+	}
+	ret.Principal = ns.Conn.Principal().Id
+	if data := ns.Conn.Data(); data != nil {
+		ret.Data = data.Items()
+	}
+	return ret
+}
+
+// newRequestId returns 16 random bytes from crypto/rand, hex encoded.
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,96 @@
+package netio
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+// memoryAdapter delivers the messages synchronously, the operations published
+// are applied when Publish returns.
+type memoryAdapter struct {
+	sync.Mutex
+	subscribers map[string][]func([]byte)
+}
+
+func (a *memoryAdapter) Publish(subj string, data []byte) error {
+	a.Lock()
+	subscribers := a.subscribers[subj]
+	a.Unlock()
+	for _, cb := range subscribers {
+		cb(data)
+	}
+	return nil
+}
+
+func (a *memoryAdapter) Subscribe(subj string, cb func([]byte)) error {
+	a.Lock()
+	defer a.Unlock()
+	a.subscribers[subj] = append(a.subscribers[subj], cb)
+	return nil
+}
+
+func TestClusterSockets(t *testing.T) {
+	adapter := &memoryAdapter{subscribers: make(map[string][]func([]byte))}
+	servers := []*Server{}
+	for i := 0; i < 2; i++ {
+		srv, _ := NewServer(nil)
+		srv.SetNodeId(strconv.Itoa(i))
+		srv.SetClusterTimeout(5 * time.Second)
+		if err := srv.SetAdapter(adapter); err != nil {
+			t.Error(err)
+			return
+		}
+		for j := 0; j < 2; j++ {
+			ns := NewNameSpace(&broadcastConn{id: fmt.Sprintf("%d-%d", i, j)}, "/chat", srv.Of("/chat").EventEmitter)
+			ns.index = srv.endpointIndex
+			ns.setConnected(true)
+		}
+		servers = append(servers, srv)
+	}
+	for _, srv := range servers {
+		assert.Equal(t, 1, len(knownNodes(srv)))
+	}
+
+	// 所有节点回复后立即返回
+	start := time.Now()
+	sockets, err := servers[0].FetchSockets(SocketFilter{Endpoint: "/chat", Except: []string{"1-1"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, 3, len(sockets))
+	assert.Equal(t, true, time.Since(start) < time.Second)
+
+	// 不回复的节点超时后被移除
+	adapter.Publish(servers[0].requestsSubject(), []byte(`{"node":"gone","op":"hello"}`))
+	assert.Equal(t, 2, len(knownNodes(servers[0])))
+	for _, srv := range servers {
+		srv.SetClusterTimeout(50 * time.Millisecond)
+	}
+	sockets, _ = servers[0].FetchSockets(SocketFilter{Endpoint: "/chat"})
+	assert.Equal(t, 4, len(sockets))
+	assert.Equal(t, []string{"1"}, knownNodes(servers[0]))
+
+	servers[0].SocketsJoin(SocketFilter{Endpoint: "/chat"}, "all")
+	assert.Equal(t, 2, len(servers[1].Of("/chat").To("all").Sockets()))
+
+	servers[1].DisconnectSockets(SocketFilter{Endpoint: "/chat", Rooms: []string{"all"}}, "")
+	sockets, _ = servers[1].FetchSockets(SocketFilter{Endpoint: "/chat"})
+	assert.Equal(t, 0, len(sockets))
+}
+
+func knownNodes(srv *Server) []string {
+	c := srv.getCluster()
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	ret := []string{}
+	for node := range c.nodes {
+		ret = append(ret, node)
+	}
+	return ret
+}
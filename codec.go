@@ -0,0 +1,114 @@
+package netio
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Codec encodes and decodes the socket.io 0.9 packets on the wire. Engine.IO
+// clients (EIO=3|4) always use their own codec.
+type Codec interface {
+	// EncodePacket encodes packet of the namespace endpoint.
+	EncodePacket(endpoint string, packet Packet) []byte
+	// DecodePayload decodes the packets of one message received from a transport.
+	DecodePayload(data []byte) ([]Packet, error)
+	// EncodePayload frames encoded packets for one write on a transport.
+	EncodePayload(packets [][]byte) []byte
+}
+
+// TextCodec is the socket.io 0.9 text codec, the default one.
+var TextCodec Codec = textCodec{}
+
+type textCodec struct{}
+
+func (textCodec) EncodePacket(endpoint string, packet Packet) []byte {
+	return encodePacket(endpoint, packet)
+}
+
+func (textCodec) DecodePayload(data []byte) ([]Packet, error) {
+	return decodePayload(data)
+}
+
+func (textCodec) EncodePayload(packets [][]byte) []byte {
+	return encodePayload(packets)
+}
+
+var InvalidPacketType = errors.New("invalid packet type")
+
+// RawPacket is the flat form of a Packet, for codecs outside this package.
+type RawPacket struct {
+	Type     MessageType
+	Id       int
+	Ack      bool
+	EndPoint string
+
+	// Name is the event name of PACKET_EVENT.
+	Name string
+	// AckId is the acknowledged id of PACKET_ACK.
+	AckId int
+	// Args are the json array of arguments of PACKET_EVENT and PACKET_ACK.
+	Args json.RawMessage
+	// Data is the data of PACKET_MESSAGE and PACKET_JSONMESSAGE, the query of
+	// PACKET_CONNECT, and the reason of PACKET_ERROR.
+	Data []byte
+	// Advice is the advice of PACKET_ERROR.
+	Advice string
+}
+
+// FlattenPacket returns the fields of packet.
+func FlattenPacket(packet Packet) *RawPacket {
+	raw := &RawPacket{
+		Type:     packet.Type(),
+		Id:       packet.Id(),
+		Ack:      packet.Ack(),
+		EndPoint: packet.EndPoint(),
+	}
+	switch p := packet.(type) {
+	case *connectPacket:
+		raw.Data = []byte(p.query)
+	case *messagePacket:
+		raw.Data = p.data
+	case *jsonPacket:
+		raw.Data = p.data
+	case *eventPacket:
+		raw.Name = p.name
+		raw.Args = p.args
+	case *ackPacket:
+		raw.AckId = p.ackId
+		raw.Args = p.args
+	case *errorPacket:
+		raw.Data = []byte(p.reason)
+		raw.Advice = p.advice
+	}
+	return raw
+}
+
+// BuildPacket returns the Packet of raw.
+func BuildPacket(raw *RawPacket) (Packet, error) {
+	common := packetCommon{
+		id:       raw.Id,
+		ack:      raw.Ack,
+		endPoint: raw.EndPoint,
+	}
+	switch raw.Type {
+	case PACKET_DISCONNECT:
+		return &disconnectPacket{packetCommon: common}, nil
+	case PACKET_CONNECT:
+		return &connectPacket{packetCommon: common, query: string(raw.Data)}, nil
+	case PACKET_HEARTBEAT:
+		return &heartbeatPacket{packetCommon: common}, nil
+	case PACKET_MESSAGE:
+		return &messagePacket{packetCommon: common, data: raw.Data}, nil
+	case PACKET_JSONMESSAGE:
+		return &jsonPacket{packetCommon: common, data: raw.Data}, nil
+	case PACKET_EVENT:
+		return &eventPacket{packetCommon: common, name: raw.Name, args: raw.Args}, nil
+	case PACKET_ACK:
+		return &ackPacket{packetCommon: common, ackId: raw.AckId, args: raw.Args}, nil
+	case PACKET_ERROR:
+		return &errorPacket{packetCommon: common, reason: string(raw.Data), advice: raw.Advice}, nil
+	case PACKET_NOOP:
+		return &noopPacket{packetCommon: common}, nil
+	}
+	return nil, InvalidPacketType
+}
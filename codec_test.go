@@ -0,0 +1,26 @@
+package netio
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestBuildPacket(t *testing.T) {
+	raw := []byte(`5:1+:/chat:{"name":"hello","args":["world"]}`)
+	packets, err := TextCodec.DecodePayload(raw)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	flat := FlattenPacket(packets[0])
+	assert.Equal(t, "hello", flat.Name)
+	assert.Equal(t, "/chat", flat.EndPoint)
+
+	packet, err := BuildPacket(flat)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, string(raw)+"\n", string(TextCodec.EncodePacket(flat.EndPoint, packet)))
+}
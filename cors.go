@@ -0,0 +1,170 @@
+package netio
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions is the cross-origin policy of the server. It applies to the
+// handshake, the polling requests, their preflights and the websocket
+// upgrades. The requests from the origin of the server, its Host, are always
+// allowed.
+type CORSOptions struct {
+	// AllowedOrigins are the origins allowed, e.g. "https://example.com". "*"
+	// allows any origin, without credentials, and "https://*.example.com" any
+	// subdomain.
+	AllowedOrigins []string
+	// AllowOrigin, if set, is asked about the origins not in AllowedOrigins.
+	AllowOrigin func(origin string, r *http.Request) bool
+	// AllowedMethods default to GET, POST and OPTIONS.
+	AllowedMethods []string
+	// AllowedHeaders of the preflights. Nil allows the headers requested.
+	AllowedHeaders []string
+	// MaxAge is how long the preflight may be cached, 0 is not sent.
+	MaxAge time.Duration
+	// AllowCredentials sends Access-Control-Allow-Credentials, so the cookies
+	// are sent by the browsers, to the origins matched by a pattern other than
+	// "*" or allowed by AllowOrigin. The origin is then echoed instead of "*".
+	AllowCredentials bool
+}
+
+// defaultCORS only allows the origin of the server.
+var defaultCORS = &CORSOptions{}
+
+var defaultCORSMethods = []string{"GET", "POST", "OPTIONS"}
+
+// SetCORS sets the cross-origin policy. Nil rejects every cross-origin
+// request. Default is nil.
+func (s *Server) SetCORS(opts *CORSOptions) {
+	if opts == nil {
+		opts = &CORSOptions{}
+	}
+	s.config.CORS = opts
+}
+
+// allowed reports whether origin may access the server, and whether with
+// credentials.
+func (o *CORSOptions) allowed(origin string, r *http.Request) (ok bool, credentials bool) {
+	for _, pattern := range o.AllowedOrigins {
+		if pattern == "*" {
+			ok = true
+		} else if matchOrigin(pattern, origin) {
+			return true, o.AllowCredentials
+		}
+	}
+	if o.AllowOrigin != nil && o.AllowOrigin(origin, r) {
+		return true, o.AllowCredentials
+	}
+	return ok, false
+}
+
+// sameOrigin reports whether origin is the origin of the server, the host r was
+// sent to.
+func sameOrigin(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+func (o *CORSOptions) methods() []string {
+	if len(o.AllowedMethods) == 0 {
+		return defaultCORSMethods
+	}
+	return o.AllowedMethods
+}
+
+// matchOrigin matches origin to pattern, in which "*" stands for any
+// characters.
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == origin
+	}
+	if !strings.HasPrefix(origin, parts[0]) {
+		return false
+	}
+	origin = origin[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(origin, part)
+		if i < 0 {
+			return false
+		}
+		origin = origin[i+len(part):]
+	}
+	return len(origin) >= len(last) && strings.HasSuffix(origin, last)
+}
+
+// CheckOrigin reports whether the Origin of r is allowed, requests without
+// Origin are. It is the origin check of the websocket upgrades.
+func (s *Server) CheckOrigin(r *http.Request) bool {
+	return s.config.CORS.checkOrigin(r)
+}
+
+func (o *CORSOptions) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(origin, r) {
+		return true
+	}
+	ok, _ := o.allowed(origin, r)
+	return ok
+}
+
+// handleCORS writes the CORS headers of r. It returns false if r was answered,
+// a preflight or a rejected origin.
+func (s *Server) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(origin, r) {
+		return true
+	}
+	opts := s.config.CORS
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	ok, credentials := opts.allowed(origin, r)
+	if !ok {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return false
+	}
+
+	if credentials {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		header.Set("Access-Control-Allow-Origin", "*")
+	}
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if r.Method != "OPTIONS" || method == "" {
+		return true
+	}
+
+	// preflight
+	allowed := false
+	for _, m := range opts.methods() {
+		if strings.EqualFold(m, method) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		http.Error(w, "method not allowed", http.StatusForbidden)
+		return false
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(opts.methods(), ", "))
+	if opts.AllowedHeaders != nil {
+		header.Set("Access-Control-Allow-Headers", strings.Join(opts.AllowedHeaders, ", "))
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		header.Set("Access-Control-Allow-Headers", requested)
+	}
+	if opts.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return false
+}
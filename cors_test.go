@@ -0,0 +1,73 @@
+package netio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestCORS(t *testing.T) {
+	assert.Equal(t, true, matchOrigin("https://*.example.com", "https://chat.example.com"))
+	assert.Equal(t, false, matchOrigin("https://*.example.com", "https://example.com"))
+	assert.Equal(t, false, matchOrigin("https://*.example.com", "https://example.com.evil.org"))
+	assert.Equal(t, true, matchOrigin("https://Example.com", "https://example.com"))
+
+	srv, client := newTestServer(t)
+	newRequest := func(method, origin string) *http.Request {
+		r, _ := http.NewRequest(method, "/socket.io/1/", strings.NewReader(""))
+		r.Header.Set("Origin", origin)
+		return r
+	}
+
+	// 默认只允许同源
+	w := httptest.NewRecorder()
+	assert.Equal(t, false, srv.handleCORS(w, newRequest("GET", "https://any.org")))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, false, srv.CheckOrigin(newRequest("GET", "https://any.org")))
+	sameOrigin := newRequest("POST", "https://example.com")
+	sameOrigin.Host = "example.com"
+	w = httptest.NewRecorder()
+	assert.Equal(t, true, srv.handleCORS(w, sameOrigin))
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, true, srv.CheckOrigin(sameOrigin))
+
+	srv.SetCORS(&CORSOptions{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedHeaders:   []string{"Content-Type"},
+		MaxAge:           time.Hour,
+		AllowCredentials: true,
+	})
+	w = httptest.NewRecorder()
+	assert.Equal(t, false, srv.handleCORS(w, newRequest("GET", "https://any.org")))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, false, srv.CheckOrigin(newRequest("GET", "https://any.org")))
+	assert.Equal(t, true, srv.CheckOrigin(newRequest("GET", "")))
+	assert.Equal(t, true, srv.handleCORS(httptest.NewRecorder(), sameOrigin))
+
+	preflight := newRequest("OPTIONS", "https://chat.example.com")
+	preflight.Header.Set("Access-Control-Request-Method", "POST")
+	w = client.serve(preflight)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://chat.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, POST, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "3600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, 0, srv.SessionCount())
+
+	preflight.Header.Set("Access-Control-Request-Method", "DELETE")
+	w = client.serve(preflight)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// "*" 不带凭据
+	srv.SetCORS(&CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	w = httptest.NewRecorder()
+	assert.Equal(t, true, srv.handleCORS(w, newRequest("GET", "https://any.org")))
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Credentials"))
+}
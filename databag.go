@@ -0,0 +1,171 @@
+package netio
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	log "github.com/cihub/seelog"
+)
+
+var NotFound = errors.New("not found")
+
+// DataStore is where the data bags of the connections are replicated to, a
+// store.Store for example.
+type DataStore interface {
+	Set(key, val string)
+	Get(key string) string
+	Has(key string) bool
+	Del(key string)
+}
+
+// DataBag holds the user data of a connection, shared by its namespaces. It is
+// safe for concurrent use.
+type DataBag struct {
+	locker sync.RWMutex
+	items  map[string]interface{}
+	store  DataStore
+	prefix string
+}
+
+func newDataBag(store DataStore, prefix string) *DataBag {
+	return &DataBag{
+		items:  make(map[string]interface{}),
+		store:  store,
+		prefix: prefix,
+	}
+}
+
+// Set sets key to val. With a DataStore, val is replicated as json. The
+// replica is written under the lock, so concurrent writes of a key leave the
+// store with the value the bag holds.
+func (d *DataBag) Set(key string, val interface{}) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+
+	d.items[key] = val
+	if d.store != nil {
+		b, err := json.Marshal(val)
+		if err != nil {
+			log.Errorf("[%s] replicate %s error %s", d.prefix, key, err)
+			return
+		}
+		d.store.Set(d.prefix+key, string(b))
+	}
+}
+
+// Get returns the value of key, nil if not set.
+func (d *DataBag) Get(key string) interface{} {
+	d.locker.RLock()
+	defer d.locker.RUnlock()
+
+	return d.items[key]
+}
+
+func (d *DataBag) Has(key string) bool {
+	d.locker.RLock()
+	defer d.locker.RUnlock()
+
+	_, ok := d.items[key]
+	return ok
+}
+
+func (d *DataBag) Del(key string) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+
+	delete(d.items, key)
+	if d.store != nil {
+		d.store.Del(d.prefix + key)
+	}
+}
+
+// Keys returns the keys set.
+func (d *DataBag) Keys() []string {
+	d.locker.RLock()
+	defer d.locker.RUnlock()
+
+	ret := make([]string, 0, len(d.items))
+	for key := range d.items {
+		ret = append(ret, key)
+	}
+	return ret
+}
+
+// Items returns a copy of the data.
+func (d *DataBag) Items() map[string]interface{} {
+	d.locker.RLock()
+	defer d.locker.RUnlock()
+
+	ret := make(map[string]interface{}, len(d.items))
+	for key, val := range d.items {
+		ret[key] = val
+	}
+	return ret
+}
+
+// clear removes the data, and its replica.
+func (d *DataBag) clear() {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+
+	items := d.items
+	d.items = make(map[string]interface{})
+	if d.store != nil {
+		for key := range items {
+			d.store.Del(d.prefix + key)
+		}
+	}
+}
+
+func (d *DataBag) lookup(key string) (interface{}, error) {
+	val := d.Get(key)
+	if val == nil {
+		return nil, NotFound
+	}
+	return val, nil
+}
+
+func (d *DataBag) GetString(key string) (string, error) {
+	val, err := d.lookup(key)
+	if err != nil {
+		return "", err
+	}
+	return String(val)
+}
+
+func (d *DataBag) GetInt(key string) (int, error) {
+	val, err := d.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	return Int(val)
+}
+
+func (d *DataBag) GetInt64(key string) (int64, error) {
+	val, err := d.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	return Int64(val)
+}
+
+func (d *DataBag) GetFloat64(key string) (float64, error) {
+	val, err := d.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	return Float64(val)
+}
+
+func (d *DataBag) GetBool(key string) (bool, error) {
+	val, err := d.lookup(key)
+	if err != nil {
+		return false, err
+	}
+	v, ok := val.(bool)
+	if !ok {
+		return false, errors.New("unknown type")
+	}
+	return v, nil
+}
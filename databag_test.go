@@ -0,0 +1,68 @@
+package netio
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+type mapStore map[string]string
+
+func (m mapStore) Set(key, val string)   { m[key] = val }
+func (m mapStore) Get(key string) string { return m[key] }
+func (m mapStore) Has(key string) bool   { _, ok := m[key]; return ok }
+func (m mapStore) Del(key string)        { delete(m, key) }
+
+func TestDataBag(t *testing.T) {
+	store := mapStore{}
+	data := newDataBag(store, "net.io.data.1.")
+	data.Set("user", "alice")
+	data.Set("age", 30)
+	data.Set("admin", true)
+
+	name, _ := data.GetString("user")
+	assert.Equal(t, "alice", name)
+	age, _ := data.GetInt("age")
+	assert.Equal(t, 30, age)
+	admin, _ := data.GetBool("admin")
+	assert.Equal(t, true, admin)
+	_, err := data.GetInt("missing")
+	assert.Equal(t, NotFound, err)
+	assert.Equal(t, `"alice"`, store["net.io.data.1.user"])
+
+	data.Del("user")
+	assert.Equal(t, false, store.Has("net.io.data.1.user"))
+	data.clear()
+	assert.Equal(t, 0, len(store))
+}
+
+// lockedStore is a mapStore safe for concurrent use.
+type lockedStore struct {
+	sync.Mutex
+	mapStore
+}
+
+func (s *lockedStore) Set(key, val string) {
+	s.Lock()
+	defer s.Unlock()
+	s.mapStore.Set(key, val)
+}
+
+func TestDataBagReplica(t *testing.T) {
+	store := &lockedStore{mapStore: mapStore{}}
+	data := newDataBag(store, "net.io.data.1.")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data.Set("n", i)
+		}(i)
+	}
+	wg.Wait()
+
+	b, _ := json.Marshal(data.Get("n"))
+	assert.Equal(t, string(b), store.mapStore["net.io.data.1.n"])
+}
@@ -0,0 +1,443 @@
+package netio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/xjtdy888/netio/transport"
+)
+
+// Engine.IO v3 (socket.io v2) and v4 (socket.io v3/v4), served under
+// /{resource}/?EIO=3|4&transport=polling|websocket[&sid=...].
+var (
+	eioV3 protocol = &eioProtocol{version: 3}
+	eioV4 protocol = &eioProtocol{version: 4}
+)
+
+// engine.io packet types
+const (
+	eioOpen    = '0'
+	eioClose   = '1'
+	eioPing    = '2'
+	eioPong    = '3'
+	eioMessage = '4'
+	eioUpgrade = '5'
+	eioNoop    = '6'
+)
+
+// socket.io packet types, carried by engine.io message packets
+const (
+	sioConnect     = '0'
+	sioDisconnect  = '1'
+	sioEvent       = '2'
+	sioAck         = '3'
+	sioError       = '4'
+	sioBinaryEvent = '5'
+	sioBinaryAck   = '6'
+)
+
+const (
+	eioRecordSep  = 0x1e
+	eioMaxPayload = 1000000
+)
+
+type eioProtocol struct {
+	version int
+}
+
+type eioOpenData struct {
+	Sid          string   `json:"sid"`
+	Upgrades     []string `json:"upgrades"`
+	PingInterval int64    `json:"pingInterval"`
+	PingTimeout  int64    `json:"pingTimeout"`
+	MaxPayload   int64    `json:"maxPayload,omitempty"`
+}
+
+func (p *eioProtocol) handshake(s *Server, c *serverConn, req *IORequest, w http.ResponseWriter, r *http.Request) {
+	creater := s.creaters.Get(req.Transport)
+	if creater.Name == "" || (req.Transport != "xhr-polling" && req.Transport != "websocket") {
+		http.Error(w, "invalid transport", http.StatusBadRequest)
+		c.Close()
+		return
+	}
+
+	data := eioOpenData{
+		Sid:          c.Id(),
+		Upgrades:     []string{},
+		PingInterval: int64(s.config.PingInterval / 1e6),
+		PingTimeout:  int64(s.config.PingTimeout / 1e6),
+	}
+	if p.version >= 4 {
+		data.MaxPayload = eioMaxPayload
+	}
+	if req.Transport == "xhr-polling" && s.config.AllowUpgrades {
+		if ws := s.creaters.Get("websocket"); ws.Upgrading {
+			data.Upgrades = append(data.Upgrades, "websocket")
+		}
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		c.Close()
+		return
+	}
+	open := append([]byte{eioOpen}, b...)
+
+	if req.Transport == "xhr-polling" {
+		payload, _ := p.encodePayload(req.Transport, [][]byte{open})
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		w.Write(payload)
+		c.onOpen()
+		return
+	}
+
+	c.Write(open)
+	t, err := creater.Server(w, r, c)
+	if err != nil {
+		c.Close()
+		return
+	}
+	c.setCurrent(req.Transport, t)
+	c.onOpen()
+}
+
+func (p *eioProtocol) encodePacket(endpoint string, packet Packet) []byte {
+	switch packet.(type) {
+	case *heartbeatPacket:
+		if p.version == 3 {
+			return []byte{eioPong}
+		}
+		return []byte{eioPing}
+	case *noopPacket:
+		return []byte{eioNoop}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte(eioMessage)
+	switch packet.(type) {
+	case *connectPacket:
+		buf.WriteByte(sioConnect)
+	case *disconnectPacket:
+		buf.WriteByte(sioDisconnect)
+	case *ackPacket:
+		buf.WriteByte(sioAck)
+	case *errorPacket:
+		buf.WriteByte(sioError)
+	default:
+		buf.WriteByte(sioEvent)
+	}
+	if endpoint != "" && endpoint != "/" {
+		buf.WriteString(endpoint)
+		buf.WriteByte(',')
+	}
+
+	switch pk := packet.(type) {
+	case *connectPacket:
+		if p.version >= 4 {
+			b, _ := json.Marshal(map[string]string{"sid": pk.sid})
+			buf.Write(b)
+		}
+	case *eventPacket:
+		if pk.Id() > 0 {
+			buf.WriteString(strconv.Itoa(pk.Id()))
+		}
+		name, _ := json.Marshal(pk.name)
+		writeEventArray(buf, name, pk.args)
+	case *ackPacket:
+		// socket.io clients count ack ids from 0, see decodeSocketPacket
+		buf.WriteString(strconv.Itoa(pk.ackId - 1))
+		if len(pk.args) == 0 || bytes.Equal(pk.args, []byte("null")) {
+			buf.WriteString("[]")
+		} else {
+			buf.Write(pk.args)
+		}
+	case *jsonPacket:
+		writeEventArray(buf, []byte(`"message"`), append(append([]byte{'['}, pk.data...), ']'))
+	case *messagePacket:
+		data, _ := json.Marshal(string(pk.data))
+		writeEventArray(buf, []byte(`"message"`), append(append([]byte{'['}, data...), ']'))
+	case *errorPacket:
+		var b []byte
+		if p.version >= 4 {
+			b, _ = json.Marshal(map[string]string{"message": pk.reason})
+		} else {
+			b, _ = json.Marshal(pk.reason)
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+// writeEventArray writes the socket.io event array [name, args...], args being
+// the json array of the arguments.
+func writeEventArray(buf *bytes.Buffer, name []byte, args []byte) {
+	args = bytes.TrimSpace(args)
+	buf.WriteByte('[')
+	buf.Write(name)
+	if len(args) > 2 && args[0] == '[' {
+		inner := bytes.TrimSpace(args[1 : len(args)-1])
+		if len(inner) > 0 {
+			buf.WriteByte(',')
+			buf.Write(inner)
+		}
+	}
+	buf.WriteByte(']')
+}
+
+func (p *eioProtocol) encodePayload(transportName string, packets [][]byte) ([]byte, int) {
+	if transportName == "websocket" || transportName == "flashsocket" {
+		return packets[0], 1
+	}
+	if len(packets) == 1 && p.version >= 4 {
+		return packets[0], 1
+	}
+	buf := &bytes.Buffer{}
+	for i, packet := range packets {
+		if p.version >= 4 {
+			if i > 0 {
+				buf.WriteByte(eioRecordSep)
+			}
+		} else {
+			buf.WriteString(strconv.Itoa(utf16Len(packet)))
+			buf.WriteByte(':')
+		}
+		buf.Write(packet)
+	}
+	return buf.Bytes(), len(packets)
+}
+
+func (p *eioProtocol) decodePayload(data []byte) (packets []Packet, err error) {
+	var frames [][]byte
+	if p.version >= 4 {
+		frames = bytes.Split(data, []byte{eioRecordSep})
+	} else {
+		frames, err = splitV3Payload(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, frame := range frames {
+		var packet Packet
+		packet, err = p.decodePacket(frame)
+		if err != nil {
+			return
+		}
+		packets = append(packets, packet)
+	}
+	return
+}
+
+func (p *eioProtocol) decodePacket(b []byte) (Packet, error) {
+	if len(b) == 0 {
+		return nil, InvalidPacket
+	}
+	switch b[0] {
+	case eioClose:
+		return new(disconnectPacket), nil
+	case eioPing, eioPong:
+		return new(heartbeatPacket), nil
+	case eioMessage:
+		return decodeSocketPacket(b[1:])
+	case eioUpgrade, eioNoop:
+		return new(noopPacket), nil
+	}
+	return nil, InvalidPacket
+}
+
+func decodeSocketPacket(b []byte) (packet Packet, err error) {
+	if len(b) == 0 {
+		return nil, InvalidPacket
+	}
+	t := b[0]
+	b = b[1:]
+	if t == sioBinaryEvent || t == sioBinaryAck {
+		return nil, errors.New("binary packets not supported")
+	}
+
+	common := packetCommon{}
+	if len(b) > 0 && b[0] == '/' {
+		i := bytes.IndexByte(b, ',')
+		if i < 0 {
+			i = len(b)
+		}
+		common.endPoint = string(b[:i])
+		if common.endPoint == "/" {
+			common.endPoint = ""
+		}
+		b = b[i:]
+		if len(b) > 0 {
+			b = b[1:]
+		}
+	}
+	i := 0
+	for i < len(b) && b[i] >= '0' && b[i] <= '9' {
+		i++
+	}
+	id := -1
+	if i > 0 {
+		id, err = strconv.Atoi(string(b[:i]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	data := b[i:]
+
+	switch t {
+	case sioConnect:
+		p := new(connectPacket)
+		p.packetCommon = common
+		p.query = string(data)
+		packet = p
+	case sioDisconnect:
+		p := new(disconnectPacket)
+		p.packetCommon = common
+		packet = p
+	case sioEvent:
+		var items []json.RawMessage
+		if err = json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return nil, InvalidPacket
+		}
+		p := new(eventPacket)
+		if err = json.Unmarshal(items[0], &p.name); err != nil {
+			return nil, err
+		}
+		p.args, err = json.Marshal(items[1:])
+		if err != nil {
+			return nil, err
+		}
+		p.packetCommon = common
+		// socket.io clients count ack ids from 0 while 0 means "no ack" here
+		if id >= 0 {
+			p.id = id + 1
+			p.ack = true
+		}
+		packet = p
+	case sioAck:
+		if id < 0 {
+			return nil, InvalidPacket
+		}
+		p := new(ackPacket)
+		p.packetCommon = common
+		p.ackId = id
+		p.args = json.RawMessage(data)
+		packet = p
+	case sioError:
+		p := new(errorPacket)
+		p.packetCommon = common
+		p.reason = string(data)
+		packet = p
+	default:
+		return nil, InvalidPacket
+	}
+	return packet, nil
+}
+
+// splitV3Payload splits an engine.io v3 payload of <length>:<packet> records,
+// lengths counted in UTF-16 code units. Websocket frames carry a single packet
+// without length.
+func splitV3Payload(data []byte) ([][]byte, error) {
+	if !isV3Payload(data) {
+		return [][]byte{data}, nil
+	}
+	frames := [][]byte{}
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, ':')
+		if i <= 0 {
+			return nil, InvalidPacket
+		}
+		n, err := strconv.Atoi(string(data[:i]))
+		if err != nil {
+			return nil, err
+		}
+		data = data[i+1:]
+		end, ok := utf16Offset(data, n)
+		if !ok {
+			return nil, InvalidPacket
+		}
+		frames = append(frames, data[:end])
+		data = data[end:]
+	}
+	return frames, nil
+}
+
+func isV3Payload(data []byte) bool {
+	for i, c := range data {
+		if c == ':' {
+			return i > 0
+		}
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return false
+}
+
+// utf16Len returns the length of b in UTF-16 code units, the unit javascript
+// counts string lengths in.
+func utf16Len(b []byte) int {
+	n := 0
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+		b = b[size:]
+	}
+	return n
+}
+
+// utf16Offset returns the byte offset of n UTF-16 code units into b.
+func utf16Offset(b []byte, n int) (int, bool) {
+	offset := 0
+	for n > 0 {
+		if offset >= len(b) {
+			return 0, false
+		}
+		r, size := utf8.DecodeRune(b[offset:])
+		if r >= 0x10000 {
+			n -= 2
+		} else {
+			n--
+		}
+		offset += size
+	}
+	return offset, n == 0
+}
+
+func (p *eioProtocol) serverPings() bool {
+	return p.version >= 4
+}
+
+func (p *eioProtocol) autoConnect() bool {
+	return p.version < 4
+}
+
+func (p *eioProtocol) ackConnect() bool {
+	return true
+}
+
+// probe answers the "2probe" ping of a websocket being upgraded to, and flushes
+// the pending poll so that the client can pause polling and send "5".
+func (p *eioProtocol) probe(c *serverConn, t transport.Server, data []byte) (bool, bool) {
+	switch string(data) {
+	case "2probe":
+		if w, ok := t.(io.Writer); ok {
+			w.Write([]byte("3probe"))
+		}
+		c.writePacket("", Noop())
+		return false, true
+	case "5":
+		return true, true
+	}
+	return false, false
+}
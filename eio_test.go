@@ -0,0 +1,45 @@
+package netio
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestEIODecodePayload(t *testing.T) {
+	raw := []byte(`13:42["hello",1]2:40`)
+	packets, err := eioV3.decodePayload(raw)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, 2, len(packets))
+	event := packets[0].(*eventPacket)
+	assert.Equal(t, "hello", event.name)
+	assert.Equal(t, `[1]`, string(event.args))
+
+	packets, err = eioV4.decodePayload([]byte("42/chat,3[\"a\"]\x1e3"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, 2, len(packets))
+	assert.Equal(t, "/chat", packets[0].EndPoint())
+	assert.Equal(t, 4, packets[0].Id())
+}
+
+func TestEIOEncodePacket(t *testing.T) {
+	packet := new(eventPacket)
+	packet.name = "hello"
+	packet.args = []byte(`["world"]`)
+	assert.Equal(t, `42/chat,["hello","world"]`, string(eioV4.encodePacket("/chat", packet)))
+
+	ack := new(ackPacket)
+	ack.ackId = 4
+	ack.args = []byte(`["ok"]`)
+	assert.Equal(t, `433["ok"]`, string(eioV4.encodePacket("", ack)))
+
+	payload, n := eioV3.encodePayload("xhr-polling", [][]byte{[]byte("40"), []byte("2")})
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "2:401:2", string(payload))
+}
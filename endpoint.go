@@ -0,0 +1,240 @@
+package netio
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+var TimeoutError = errors.New("time out")
+
+// Endpoint is a namespace defined by Server.Of: the event handlers of its
+// connections, and the broadcasts to them.
+type Endpoint struct {
+	*EventEmitter
+	BroadcastOperator
+}
+
+func newEndpoint(srv *Server, name string) *Endpoint {
+	return &Endpoint{
+		EventEmitter:      NewEventEmitter(),
+		BroadcastOperator: BroadcastOperator{srv: srv, endpoint: name},
+	}
+}
+
+// Name returns the endpoint of the namespace.
+func (e *Endpoint) Name() string {
+	return e.endpoint
+}
+
+// BroadcastOperator selects the connections of a namespace a broadcast is sent
+// to. The operators return a new BroadcastOperator and never modify the one
+// they are called on, so a BroadcastOperator can be kept and reused.
+type BroadcastOperator struct {
+	srv      *Server
+	endpoint string
+	rooms    []string
+	except   []string
+	local    bool
+	volatile bool
+	compress bool
+	timeout  time.Duration
+}
+
+// To restricts the broadcast to the connections in any of rooms, see NameSpace.Join.
+func (b BroadcastOperator) To(rooms ...string) BroadcastOperator {
+	b.rooms = append(append([]string{}, b.rooms...), rooms...)
+	return b
+}
+
+// Except excludes the connections of the session ids, or in the rooms, ids.
+func (b BroadcastOperator) Except(ids ...string) BroadcastOperator {
+	b.except = append(append([]string{}, b.except...), ids...)
+	return b
+}
+
+// Local restricts the broadcast to the connections of this server, Emit is not
+// relayed to the other nodes of the cluster.
+func (b BroadcastOperator) Local() BroadcastOperator {
+	b.local = true
+	return b
+}
+
+// Volatile skips the connections which have no transport to write to, instead
+// of queuing the packets for them.
+func (b BroadcastOperator) Volatile() BroadcastOperator {
+	b.volatile = true
+	return b
+}
+
+// Compress sets whether the packets are compressed. Only the websocket
+// connections which negotiated permessage-deflate compress them, it is a no-op
+// for the polling and streaming transports. The packets are not compressed by
+// default.
+func (b BroadcastOperator) Compress(compress bool) BroadcastOperator {
+	b.compress = compress
+	return b
+}
+
+// Timeout sets how long EmitWithAck waits for the acknowledgements. Default is
+// the reliable timeout of the server.
+func (b BroadcastOperator) Timeout(d time.Duration) BroadcastOperator {
+	b.timeout = d
+	return b
+}
+
+// Emit broadcasts the event to the selected connections. Unless Local is set,
+// the event is also relayed to the other nodes of the cluster, see SetAdapter.
+// The result counts the connections of this node.
+func (b BroadcastOperator) Emit(name string, args ...interface{}) BroadcastResult {
+	broadcaster := &Broadcaster{Namespaces: b.Sockets(), Workers: b.srv.config.BroadcastWorkers, Compress: b.compress}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return BroadcastResult{Failed: len(broadcaster.Namespaces)}
+	}
+	result := broadcaster.broadcast(name, data)
+
+	if c := b.srv.getCluster(); c != nil && !b.local {
+		req := &socketsRequest{
+			Id:       newRequestId(),
+			Node:     b.srv.config.NodeId,
+			Op:       socketsEmit,
+			Filter:   SocketFilter{Endpoint: b.endpoint, Rooms: b.rooms, Except: b.except},
+			Event:    name,
+			Args:     data,
+			Volatile: b.volatile,
+			Compress: b.compress,
+		}
+		if err := b.srv.publishSockets(c, req); err != nil {
+			log.Errorf("publish broadcast %s error %s", name, err)
+		}
+	}
+	return result
+}
+
+// AckResponse is the acknowledgement of one connection to EmitWithAck.
+type AckResponse struct {
+	// Id is the session id of the connection.
+	Id string
+	// Args is the json array of the arguments the client acknowledged with.
+	Args json.RawMessage
+	// Err is TimeoutError if the client did not acknowledge in time, or the
+	// error sending the event.
+	Err error
+}
+
+// EmitWithAck broadcasts the event to the selected connections of this node,
+// and waits for their acknowledgements until the timeout. It returns a response
+// for every connection, and the first error of the responses.
+func (b BroadcastOperator) EmitWithAck(name string, args ...interface{}) ([]AckResponse, error) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	timeout := b.timeout
+	if timeout <= 0 {
+		timeout = b.srv.config.ReliableTimeout
+	}
+
+	targets := b.Sockets()
+	responses := make([]AckResponse, len(targets))
+	waiting := make([]chan []byte, len(targets))
+	for i, ns := range targets {
+		responses[i].Id = ns.Id()
+		c, release, err := ns.emitWithAck(name, data, b.compress)
+		if err != nil {
+			responses[i].Err = err
+			continue
+		}
+		defer release()
+		waiting[i] = c
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	expired := false
+	for i, c := range waiting {
+		if c == nil {
+			continue
+		}
+		if expired {
+			select {
+			case reply := <-c:
+				responses[i].Args = reply
+			default:
+				responses[i].Err = TimeoutError
+			}
+			continue
+		}
+		select {
+		case reply := <-c:
+			responses[i].Args = reply
+		case <-timer.C:
+			expired = true
+			responses[i].Err = TimeoutError
+		}
+	}
+
+	for _, response := range responses {
+		if response.Err != nil {
+			return responses, response.Err
+		}
+	}
+	return responses, nil
+}
+
+// Sockets returns the selected connections.
+func (b BroadcastOperator) Sockets() []*NameSpace {
+	index := b.srv.endpointIndex
+	var namespaces []*NameSpace
+	if len(b.rooms) == 0 {
+		namespaces = index.namespaces(b.endpoint)
+	} else {
+		seen := make(map[*NameSpace]bool)
+		for _, room := range b.rooms {
+			for _, ns := range index.namespaces(roomKey(b.endpoint, room)) {
+				if !seen[ns] {
+					seen[ns] = true
+					namespaces = append(namespaces, ns)
+				}
+			}
+		}
+	}
+
+	ret := namespaces[:0]
+	for _, ns := range namespaces {
+		if b.excluded(ns) {
+			continue
+		}
+		if b.volatile && !writable(ns.Conn) {
+			continue
+		}
+		ret = append(ret, ns)
+	}
+	return ret
+}
+
+func (b BroadcastOperator) excluded(ns *NameSpace) bool {
+	for _, id := range b.except {
+		if ns.Id() == id || ns.In(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// writable reports whether conn has a transport to write to.
+func writable(conn Conn) bool {
+	if c, ok := conn.(*serverConn); ok {
+		s := c.getState()
+		return (s == stateNormal || s == stateUpgrading) && c.getCurrent() != nil
+	}
+	return true
+}
+
+// roomKey is the key of room of endpoint in the endpointIndex.
+func roomKey(endpoint, room string) string {
+	return endpoint + "\x00" + room
+}
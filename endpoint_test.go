@@ -0,0 +1,180 @@
+package netio
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+	"github.com/gorilla/websocket"
+)
+
+func TestBroadcastOperator(t *testing.T) {
+	srv, err := NewServer(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	chat := srv.Of("/chat")
+	namespaces := []*NameSpace{}
+	for i := 0; i < 3; i++ {
+		ns := NewNameSpace(&broadcastConn{id: strconv.Itoa(i)}, "/chat", chat.EventEmitter)
+		ns.index = srv.endpointIndex
+		ns.setConnected(true)
+		namespaces = append(namespaces, ns)
+	}
+	namespaces[0].Join("red")
+	namespaces[1].Join("red", "blue")
+
+	red := chat.To("red")
+	assert.Equal(t, 2, len(red.Sockets()))
+	assert.Equal(t, 1, len(red.Except("0").Sockets()))
+	assert.Equal(t, 0, len(red.Except("blue", "0").Sockets()))
+	assert.Equal(t, 2, len(red.Sockets()))
+	assert.Equal(t, 3, len(chat.Sockets()))
+	assert.Equal(t, []string{"blue", "red"}, namespaces[1].Rooms())
+
+	namespaces[1].setConnected(false)
+	assert.Equal(t, 1, len(red.Sockets()))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ack := new(ackPacket)
+		ack.ackId = 1
+		ack.args = []byte(`["ok"]`)
+		namespaces[0].onAckPacket(ack)
+	}()
+	responses, err := chat.Timeout(100*time.Millisecond).EmitWithAck("ping")
+	assert.Equal(t, TimeoutError, err)
+	assert.Equal(t, 2, len(responses))
+	for _, response := range responses {
+		if response.Id == "0" {
+			assert.Equal(t, `["ok"]`, string(response.Args))
+		} else {
+			assert.Equal(t, TimeoutError, response.Err)
+		}
+	}
+}
+
+// relayConn records the packets written to it.
+type relayConn struct {
+	broadcastConn
+	written chan string
+}
+
+func (c *relayConn) Write(p []byte) (int, error) {
+	c.written <- string(p)
+	return len(p), nil
+}
+
+func TestBroadcastRelay(t *testing.T) {
+	adapter := &memoryAdapter{subscribers: make(map[string][]func([]byte))}
+	servers := []*Server{}
+	conns := []*relayConn{}
+	for i := 0; i < 2; i++ {
+		srv, _ := NewServer(nil)
+		srv.SetNodeId(strconv.Itoa(i))
+		if err := srv.SetAdapter(adapter); err != nil {
+			t.Error(err)
+			return
+		}
+		conn := &relayConn{broadcastConn{id: strconv.Itoa(i)}, make(chan string, 4)}
+		ns := NewNameSpace(conn, "/chat", srv.Of("/chat").EventEmitter)
+		ns.index = srv.endpointIndex
+		ns.setConnected(true)
+		servers = append(servers, srv)
+		conns = append(conns, conn)
+	}
+
+	result := servers[0].Of("/chat").Emit("news", "hello")
+	assert.Equal(t, BroadcastResult{Delivered: 1}, result)
+	for _, conn := range conns {
+		select {
+		case p := <-conn.written:
+			assert.Equal(t, "5::/chat:{\"name\":\"news\",\"args\":[\"hello\"]}\n", p)
+		default:
+			t.Errorf("%s: no broadcast", conn.id)
+		}
+	}
+
+	servers[0].Of("/chat").Except("1").Emit("news", "except")
+	servers[0].Of("/chat").Local().Emit("news", "local")
+	assert.Equal(t, true, strings.Contains(<-conns[0].written, "except"))
+	assert.Equal(t, true, strings.Contains(<-conns[0].written, "local"))
+	assert.Equal(t, 0, len(conns[1].written))
+}
+
+// recordConn records the bytes read from the server.
+type recordConn struct {
+	net.Conn
+	read []byte
+}
+
+func (c *recordConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read = append(c.read, p[:n]...)
+	return n, err
+}
+
+// compressedFrames returns the RSV1 bit of the short frames read after the
+// websocket handshake.
+func compressedFrames(read []byte) []bool {
+	data := read[bytes.Index(read, []byte("\r\n\r\n"))+4:]
+	ret := []bool{}
+	for len(data) >= 2 {
+		ret = append(ret, data[0]&0x40 != 0)
+		data = data[2+int(data[1]&0x7f):]
+	}
+	return ret
+}
+
+func TestBroadcastCompress(t *testing.T) {
+	srv, client := newTestServer(t)
+	url := client.listen()
+	sid := client.handshake()
+
+	conn := &recordConn{}
+	dialer := websocket.Dialer{
+		EnableCompression: true,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			c, err := net.Dial(network, addr)
+			conn.Conn = c
+			return conn, err
+		},
+	}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/socket.io/1/websocket/"+sid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	read := func() string {
+		_, message, err := ws.ReadMessage()
+		assert.Equal(t, nil, err)
+		return string(message)
+	}
+
+	assert.Equal(t, "1::", read())
+	srv.Of("").Compress(true).Emit("news", "compressed")
+	assert.Equal(t, true, strings.Contains(read(), "compressed"))
+	srv.Of("").Emit("news", "plain")
+	assert.Equal(t, true, strings.Contains(read(), "plain"))
+	assert.Equal(t, []bool{false, true, false}, compressedFrames(conn.read))
+}
+
+func TestEndpointIndex(t *testing.T) {
+	index := newEndpointIndex()
+	ns := NewNameSpace(&broadcastConn{id: "a"}, "/chat", NewEventEmitter())
+	ns.index = index
+	ns.setConnected(true)
+	ns.Join("red")
+	assert.Equal(t, 2, len(index.endpoints))
+
+	ns.Leave("red")
+	assert.Equal(t, 1, len(index.endpoints))
+	ns.setConnected(false)
+	assert.Equal(t, 0, len(index.endpoints))
+}
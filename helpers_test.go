@@ -0,0 +1,61 @@
+package netio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testClient sends the requests of a test to its server.
+type testClient struct {
+	t   *testing.T
+	srv *Server
+}
+
+// newTestServer returns a server with the "socket.io" resource, and a client of
+// it.
+func newTestServer(t *testing.T) (*Server, *testClient) {
+	srv, err := NewServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetResourceName("socket.io")
+	return srv, &testClient{t: t, srv: srv}
+}
+
+// serve returns the response of the server to r.
+func (c *testClient) serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c.srv.ServeHTTP(w, r)
+	return w
+}
+
+func (c *testClient) request(method, path, body string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return c.serve(r)
+}
+
+func (c *testClient) get(path string) *httptest.ResponseRecorder {
+	return c.request("GET", path, "")
+}
+
+func (c *testClient) post(path, body string) *httptest.ResponseRecorder {
+	return c.request("POST", path, body)
+}
+
+// handshake opens a socket.io 0.9 session and returns its sid.
+func (c *testClient) handshake() string {
+	return strings.Split(c.get("/socket.io/1/").Body.String(), ":")[0]
+}
+
+// listen serves the server over http until the test ends, for the websockets,
+// and returns its url.
+func (c *testClient) listen() string {
+	ts := httptest.NewServer(c.srv)
+	c.t.Cleanup(ts.Close)
+	return ts.URL
+}
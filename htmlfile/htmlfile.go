@@ -0,0 +1,11 @@
+package htmlfile
+
+import (
+	"github.com/xjtdy888/netio/transport"
+)
+
+var Creater = transport.Creater{
+	Name:      "htmlfile",
+	Upgrading: false,
+	Server:    NewServer,
+}
@@ -0,0 +1,37 @@
+package htmlfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/xjtdy888/netio/transport"
+)
+
+// prelude opens the forever-iframe document. The padding makes browsers start
+// rendering, and running scripts, before the first chunk arrives.
+var prelude = "<html><body><script>var _ = function (msg) { parent.s._(msg, document); };</script>" +
+	strings.Repeat(" ", 173)
+
+// NewServer returns a stream of <script> chunks of a never ending html
+// document. Packets are received by POST like xhr-polling.
+func NewServer(w http.ResponseWriter, r *http.Request, callback transport.Callback) (transport.Server, error) {
+	return transport.NewStream(callback, transport.StreamFormat{
+		Header: http.Header{
+			"Content-Type": {"text/html; charset=UTF-8"},
+			"Connection":   {"keep-alive"},
+		},
+		Prelude: prelude,
+		Encode:  encodeScript,
+	}), nil
+}
+
+// encodeScript frames data as a call of the iframe callback.
+func encodeScript(data []byte) ([]byte, error) {
+	jd, err := json.Marshal(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("<script>_(%s);</script>", jd)), nil
+}
@@ -0,0 +1,88 @@
+package htmlfile
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+	"github.com/xjtdy888/netio/transport"
+)
+
+type fakeCallback struct {
+	sender   chan []byte
+	received chan []byte
+	closed   chan transport.Server
+}
+
+func newFakeCallback() *fakeCallback {
+	return &fakeCallback{
+		sender:   make(chan []byte),
+		received: make(chan []byte, 1),
+		closed:   make(chan transport.Server, 1),
+	}
+}
+
+func (c *fakeCallback) SenderChan() chan []byte         { return c.sender }
+func (c *fakeCallback) OnRawMessage(data []byte)        { c.received <- data }
+func (c *fakeCallback) OnRawDispatchRemote(data []byte) {}
+func (c *fakeCallback) OnClose(server transport.Server) { c.closed <- server }
+func (c *fakeCallback) PollingTimeout() time.Duration   { return 0 }
+func (c *fakeCallback) Noop() []byte                    { return []byte("8::") }
+
+func TestStream(t *testing.T) {
+	callback := newFakeCallback()
+	s, _ := NewServer(nil, nil, callback)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/html; charset=UTF-8", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+	b := make([]byte, len(prelude))
+	io.ReadFull(reader, b)
+	assert.Equal(t, prelude, string(b))
+
+	callback.sender <- []byte(`3:::</script>"hi"`)
+	chunk := `<script>_("3:::\u003c/script\u003e\"hi\"");</script>`
+	b = make([]byte, len(chunk))
+	io.ReadFull(reader, b)
+	assert.Equal(t, chunk, string(b))
+
+	post, err := http.Post(ts.URL, "text/plain", strings.NewReader("data=3:::hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Body.Close()
+	assert.Equal(t, "3:::hello", string(<-callback.received))
+
+	s.Close()
+	assert.Equal(t, s, <-callback.closed)
+	rest, _ := ioutil.ReadAll(reader)
+	assert.Equal(t, "", string(rest))
+}
+
+func TestStreamClientGone(t *testing.T) {
+	callback := newFakeCallback()
+	s, _ := NewServer(nil, nil, callback)
+	// ResponseRecorder 不是 CloseNotifier
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	ctx, cancel := context.WithCancel(r.Context())
+
+	go s.ServeHTTP(w, r.WithContext(ctx))
+	callback.sender <- []byte("3:::hello")
+	cancel()
+	assert.Equal(t, s, <-callback.closed)
+	assert.Equal(t, prelude+`<script>_("3:::hello");</script>`, w.Body.String())
+}
@@ -0,0 +1,95 @@
+package netio
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	tooManyConnections = "too many connections"
+	tooManyFromIP      = "too many connections from ip"
+	tooManyPrincipal   = "too many connections of principal"
+	tooManyNamespace   = "too many connections to namespace"
+)
+
+// ConnectionLimits are the limits of concurrent sessions. 0 is no limit.
+type ConnectionLimits struct {
+	// PerIP limits the sessions of a client ip, see Server.ClientAddr.
+	PerIP int
+	// PerPrincipal limits the sessions of an authenticated principal, see
+	// SetAuthenticator.
+	PerPrincipal int
+	// PerNamespace limits the connections to each namespace but the default
+	// one, which SetMaxConnection limits.
+	PerNamespace int
+}
+
+// SetConnectionLimits sets the limits of concurrent sessions. Default is no limit.
+func (s *Server) SetConnectionLimits(limits ConnectionLimits) {
+	s.config.Limits = limits
+}
+
+// acquire counts a new session of ip and principal, or returns the reason it
+// exceeds the limits.
+func (s *Server) acquire(ip, principal string) string {
+	n := atomic.AddInt32(&s.currentConnection, 1)
+	if s.config.MaxConnection > 0 && int(n) > s.config.MaxConnection {
+		atomic.AddInt32(&s.currentConnection, -1)
+		s.stats.ConnectionRejected()
+		return tooManyConnections
+	}
+	if !s.stats.acquire(s.stats.clients, ip, s.config.Limits.PerIP) {
+		atomic.AddInt32(&s.currentConnection, -1)
+		s.stats.ConnectionRejected()
+		return tooManyFromIP
+	}
+	if principal != "" && !s.stats.acquire(s.stats.principals, principal, s.config.Limits.PerPrincipal) {
+		s.stats.release(s.stats.clients, ip)
+		atomic.AddInt32(&s.currentConnection, -1)
+		s.stats.ConnectionRejected()
+		return tooManyPrincipal
+	}
+	return ""
+}
+
+// release uncounts a session acquired.
+func (s *Server) release(ip, principal string) {
+	atomic.AddInt32(&s.currentConnection, -1)
+	s.stats.release(s.stats.clients, ip)
+	if principal != "" {
+		s.stats.release(s.stats.principals, principal)
+	}
+}
+
+func (s *Server) acquireNamespace(endpoint string) bool {
+	if !s.stats.acquire(s.stats.namespaces, endpoint, s.config.Limits.PerNamespace) {
+		s.stats.ConnectionRejected()
+		return false
+	}
+	return true
+}
+
+func (s *Server) releaseNamespace(endpoint string) {
+	s.stats.release(s.stats.namespaces, endpoint)
+}
+
+// reject answers req with the error reason, in the format of its protocol:
+// an error packet encoded with the codec of the socket.io 0.9 clients, see
+// SetCodec, the json error of the engine.io servers with code for engine.io
+// clients.
+func (s *Server) reject(req *IORequest, w http.ResponseWriter, status int, code int, reason string) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if req.EIO > 0 {
+		b, _ := json.Marshal(map[string]interface{}{"code": code, "message": reason})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(b)
+		return
+	}
+	packet := new(errorPacket)
+	packet.reason = reason
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(s.protoV1.encodePacket("", packet))
+}
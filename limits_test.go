@@ -0,0 +1,73 @@
+package netio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestConnectionLimits(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.SetConnectionLimits(ConnectionLimits{PerIP: 1, PerPrincipal: 1, PerNamespace: 1})
+	srv.SetAuthenticator(func(r *http.Request) (Principal, error) {
+		return Principal{Id: r.URL.Query().Get("user")}, nil
+	})
+	srv.Of("/chat")
+	handshake := func(remote, user string) (*httptest.ResponseRecorder, *serverConn) {
+		r, _ := http.NewRequest("GET", "/socket.io/1/?user="+user, strings.NewReader(""))
+		r.RemoteAddr = remote
+		w := client.serve(r)
+		conn, _ := srv.GetSessionManager().Get(strings.Split(w.Body.String(), ":")[0]).(*serverConn)
+		return w, conn
+	}
+	waitClosed := func(n int) {
+		for i := 0; i < 100 && srv.SessionCount() > n; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	_, alice := handshake("198.51.100.1:1000", "alice")
+	w, _ := handshake("198.51.100.1:1001", "bob")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "7:::"+tooManyFromIP, w.Body.String())
+	w, _ = handshake("198.51.100.2:1000", "alice")
+	assert.Equal(t, "7:::"+tooManyPrincipal, w.Body.String())
+	_, bob := handshake("198.51.100.2:1000", "bob")
+	assert.Equal(t, true, bob != nil)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&srv.currentConnection))
+
+	assert.Equal(t, true, alice.admitNamespace(nil, "/chat"))
+	chat := alice.open("/chat")
+	chat.onConnect()
+	assert.Equal(t, 1, srv.Stats().NamespaceConnections("/chat"))
+	assert.Equal(t, false, bob.admitNamespace(nil, "/chat"))
+	assert.Equal(t, true, bob.admitNamespace(nil, "/undefined"))
+	chat.onDisconnect()
+	assert.Equal(t, 0, srv.Stats().NamespaceConnections("/chat"))
+	assert.Equal(t, true, bob.admitNamespace(nil, "/chat"))
+	bob.open("/chat").onConnect()
+
+	stats := srv.Stats().Dump()
+	assert.Equal(t, 2, stats.ActiveClients)
+	assert.Equal(t, 2, stats.ActivePrincipals)
+	assert.Equal(t, int64(3), stats.RejectedConnections)
+
+	alice.Close()
+	bob.Close()
+	waitClosed(0)
+	alice.callback.onClose(alice.Id())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&srv.currentConnection))
+	stats = srv.Stats().Dump()
+	assert.Equal(t, 0, stats.ActiveClients)
+	assert.Equal(t, 0, stats.ActivePrincipals)
+	assert.Equal(t, 0, stats.Namespaces["/chat"])
+
+	_, alice = handshake("198.51.100.1:1000", "alice")
+	assert.Equal(t, true, alice != nil)
+	alice.Close()
+}
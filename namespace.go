@@ -3,20 +3,39 @@ package netio
 import (
 	log "github.com/cihub/seelog"
 	"encoding/json"
-	"errors"
+	"sort"
 	"sync"
 	"time"
 )
 
+// reliableMessage is an event sent by EmitReliable and not yet acknowledged.
+type reliableMessage struct {
+	packet *eventPacket
+	// written is set once the event is handed to a transport, at sentAt.
+	written bool
+	sentAt  time.Time
+	retries int
+	onError func(error)
+}
+
 type NameSpace struct {
 	sync.Mutex
 	*EventEmitter
 	endpoint    string
+	proto       protocol
+	index       *endpointIndex
+	rooms       map[string]bool
 	Conn     Conn
 	connected   bool
 	id          int
 	waitingLock sync.Mutex
 	waiting     map[int]chan []byte
+
+	reliableLock sync.Mutex
+	reliable     map[int]*reliableMessage
+
+	// onLeave is called when the namespace disconnects, outside its lock.
+	onLeave func()
 }
 
 func NewNameSpace(conn Conn, endpoint string, ee *EventEmitter) *NameSpace {
@@ -27,6 +46,8 @@ func NewNameSpace(conn Conn, endpoint string, ee *EventEmitter) *NameSpace {
 		connected:    false,
 		id:           1,
 		waiting:      make(map[int]chan []byte),
+		reliable:     make(map[int]*reliableMessage),
+		rooms:        make(map[string]bool),
 	}
 	return ret
 }
@@ -39,6 +60,32 @@ func (ns *NameSpace) Id() string {
 	return ns.Conn.Id()
 }
 
+// ClientIP returns the ip of the client, resolved through the trusted proxies.
+func (ns *NameSpace) ClientIP() string {
+	if h := ns.Conn.Handshake(); h != nil {
+		return h.Address
+	}
+	return ""
+}
+
+// Secure reports whether the client connected over https.
+func (ns *NameSpace) Secure() bool {
+	if h := ns.Conn.Handshake(); h != nil {
+		return h.Secure
+	}
+	return false
+}
+
+// Principal returns the client authenticated by the handshake.
+func (ns *NameSpace) Principal() Principal {
+	return ns.Conn.Principal()
+}
+
+// Data returns the user data of the connection.
+func (ns *NameSpace) Data() *DataBag {
+	return ns.Conn.Data()
+}
+
 func (ns *NameSpace) Call(name string, timeout time.Duration, reply []interface{}, args ...interface{}) error {
 	if !ns.isConnected() {
 		return NotConnected
@@ -50,7 +97,7 @@ func (ns *NameSpace) Call(name string, timeout time.Duration, reply []interface{
 	pack.name = name
 	if len(reply) > 0 {
 		pack.ack = true
-		c = make(chan []byte)
+		c = make(chan []byte, 1)
 
 		ns.waitingLock.Lock()
 		pack.id = ns.id
@@ -83,7 +130,7 @@ func (ns *NameSpace) Call(name string, timeout time.Duration, reply []interface{
 				return err
 			}
 		case <-time.After(timeout):
-			return errors.New("time out")
+			return TimeoutError
 		}
 	}
 
@@ -111,6 +158,82 @@ func (ns *NameSpace) Emit(name string, args ...interface{}) error {
 	return nil
 }
 
+// emitWithAck emits an event asking for an acknowledgement. It returns the
+// channel receiving the ack arguments, and the func to release it.
+func (ns *NameSpace) emitWithAck(name string, args json.RawMessage, compress bool) (chan []byte, func(), error) {
+	if !ns.isConnected() {
+		return nil, nil, NotConnected
+	}
+
+	pack := new(eventPacket)
+	pack.endPoint = ns.endpoint
+	pack.name = name
+	pack.args = args
+	pack.ack = true
+	c := make(chan []byte, 1)
+
+	ns.waitingLock.Lock()
+	pack.id = ns.id
+	ns.id++
+	ns.waiting[pack.id] = c
+	ns.waitingLock.Unlock()
+
+	release := func() {
+		ns.waitingLock.Lock()
+		defer ns.waitingLock.Unlock()
+		delete(ns.waiting, pack.id)
+	}
+	if err := ns.sendMessage(pack, outgoing{compress: compress}); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return c, release, nil
+}
+
+// EmitReliable emits an event that is kept until the client acknowledges it,
+// so the client handler must invoke its ack callback. Unacknowledged events are
+// retransmitted on later transport writes and when the session resumes. If the
+// event is still unacknowledged after the configured retries, or the namespace
+// disconnects, onError is called with DeliveryError or ClosedError.
+func (ns *NameSpace) EmitReliable(name string, onError func(error), args ...interface{}) error {
+	if !ns.isConnected() {
+		return NotConnected
+	}
+
+	pack := new(eventPacket)
+	pack.endPoint = ns.endpoint
+	pack.name = name
+	pack.ack = true
+
+	var err error
+	pack.args, err = json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	ns.waitingLock.Lock()
+	pack.id = ns.id
+	ns.id++
+	ns.waitingLock.Unlock()
+
+	msg := &reliableMessage{
+		packet:  pack,
+		onError: onError,
+	}
+	ns.reliableLock.Lock()
+	ns.reliable[pack.id] = msg
+	ns.reliableLock.Unlock()
+
+	err = ns.sendReliable(msg)
+	if err != nil {
+		ns.reliableLock.Lock()
+		delete(ns.reliable, pack.id)
+		ns.reliableLock.Unlock()
+		return err
+	}
+	return nil
+}
+
 func (ns *NameSpace) Send(message interface{}) error {
 	if !ns.isConnected() {
 		return NotConnected
@@ -150,13 +273,93 @@ func (ns *NameSpace) onPacket(packet Packet) {
 }
 
 func (ns *NameSpace) onAckPacket(packet *ackPacket) {
+	ns.reliableLock.Lock()
+	_, ok := ns.reliable[packet.ackId]
+	delete(ns.reliable, packet.ackId)
+	ns.reliableLock.Unlock()
+	if ok {
+		return
+	}
+
+	ns.waitingLock.Lock()
 	c, ok := ns.waiting[packet.ackId]
+	ns.waitingLock.Unlock()
 	if !ok {
 		return
 	}
 	c <- []byte(packet.args)
 }
 
+// sendReliable queues msg, which counts as sent once a transport takes it.
+func (ns *NameSpace) sendReliable(msg *reliableMessage) error {
+	return ns.sendMessage(msg.packet, outgoing{written: func() {
+		ns.reliableLock.Lock()
+		msg.written = true
+		msg.sentAt = time.Now()
+		ns.reliableLock.Unlock()
+	}})
+}
+
+// retransmit resends unacknowledged reliable events in the order they were
+// emitted. Events still queued, never handed to a transport, are not resent.
+// Events that ran out of retries are dropped and reported.
+func (ns *NameSpace) retransmit(force bool, timeout time.Duration, retries int) {
+	if !ns.isConnected() {
+		return
+	}
+
+	now := time.Now()
+	resend := []*reliableMessage{}
+	failed := []*reliableMessage{}
+
+	ns.reliableLock.Lock()
+	for id, msg := range ns.reliable {
+		if !msg.written || !force && now.Sub(msg.sentAt) < timeout {
+			continue
+		}
+		if msg.retries >= retries {
+			delete(ns.reliable, id)
+			failed = append(failed, msg)
+			continue
+		}
+		msg.retries++
+		msg.written = false
+		resend = append(resend, msg)
+	}
+	ns.reliableLock.Unlock()
+
+	sort.Sort(reliableById(resend))
+	for _, msg := range resend {
+		log.Debugf("[%s][%s] retransmit %d (%d)", ns.Id(), ns.endpoint, msg.packet.id, msg.retries)
+		ns.sendReliable(msg)
+	}
+	for _, msg := range failed {
+		if msg.onError != nil {
+			msg.onError(DeliveryError)
+		}
+	}
+}
+
+// failReliable gives up every pending reliable event with err.
+func (ns *NameSpace) failReliable(err error) {
+	ns.reliableLock.Lock()
+	pending := ns.reliable
+	ns.reliable = make(map[int]*reliableMessage)
+	ns.reliableLock.Unlock()
+
+	for _, msg := range pending {
+		if msg.onError != nil {
+			msg.onError(err)
+		}
+	}
+}
+
+type reliableById []*reliableMessage
+
+func (r reliableById) Len() int           { return len(r) }
+func (r reliableById) Less(i, j int) bool { return r[i].packet.id < r[j].packet.id }
+func (r reliableById) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
 func (ns *NameSpace) onEventPacket(packet *eventPacket) {
 	callback := func(args []interface{}) {
 		ack := new(ackPacket)
@@ -176,18 +379,55 @@ func (ns *NameSpace) onEventPacket(packet *eventPacket) {
 }
 
 func (ns *NameSpace) sendPacket(packet Packet) error {
+	return ns.sendMessage(packet, outgoing{})
+}
+
+// sendMessage writes packet with the options of msg.
+func (ns *NameSpace) sendMessage(packet Packet, msg outgoing) error {
 
-	packByte := encodePacket(ns.endpoint, packet)
+	msg.data = ns.getProto().encodePacket(ns.endpoint, packet)
 	if !ns.isConnected() {
-		log.Warnf("[%s][%s] %s [%s]", ns.Id(), ns.endpoint, "not connected", string(packByte))
+		log.Warnf("[%s][%s] %s [%s]", ns.Id(), ns.endpoint, "not connected", string(msg.data))
 		return NotConnected
 	}
 
-	log.Tracef("[%s] sendPacket [%s]",  ns.Id(), string(packByte))
-	_, err := ns.Conn.Write(packByte)
+	log.Tracef("[%s] sendPacket [%s]",  ns.Id(), string(msg.data))
+	return ns.write(msg)
+}
+
+// sendRaw writes an encoded packet, see Broadcaster.
+func (ns *NameSpace) sendRaw(packByte []byte, compress bool) error {
+	if !ns.isConnected() {
+		return NotConnected
+	}
+	return ns.write(outgoing{data: packByte, compress: compress})
+}
+
+// messageWriter is implemented by the Conns queuing packets with their options.
+type messageWriter interface {
+	writeMessage(msg outgoing) error
+}
+
+// write queues msg on the connection. The options are dropped for the Conns
+// which only implement Write, msg counts as written once Write returns.
+func (ns *NameSpace) write(msg outgoing) error {
+	if w, ok := ns.Conn.(messageWriter); ok {
+		return w.writeMessage(msg)
+	}
+	_, err := ns.Conn.Write(msg.data)
+	if err == nil && msg.written != nil {
+		msg.written()
+	}
 	return err
 }
 
+func (ns *NameSpace) getProto() protocol {
+	if ns.proto == nil {
+		return defaultProtocol
+	}
+	return ns.proto
+}
+
 
 func (ns *NameSpace) onMessage(p *jsonPacket) error {
 	if ns.isConnected() {
@@ -204,7 +444,13 @@ func (ns *NameSpace) onConnect() {
 	if ns.isConnected() == false {
 		ns.emit("connect", ns, nil)
 		ns.setConnected(true)
-		ns.Emit("connect")
+		if !ns.getProto().ackConnect() {
+			ns.Emit("connect")
+			return
+		}
+		packet := new(connectPacket)
+		packet.sid = ns.Id()
+		ns.sendPacket(packet)
 	}
 }
 
@@ -212,13 +458,89 @@ func (ns *NameSpace) onDisconnect() {
 	ns.sendPacket(new(disconnectPacket))
 	ns.emit("disconnect", ns, nil)
 	ns.setConnected(false)
+	ns.failReliable(ClosedError)
 }
 
 func (ns *NameSpace) setConnected(c bool) {
 	ns.Lock()
-	defer ns.Unlock()
-	
+	leaving := ns.connected && !c
+	if ns.index != nil && ns.connected != c {
+		if c {
+			ns.index.add(ns.endpoint, ns)
+		} else {
+			ns.index.remove(ns.endpoint, ns)
+			for room := range ns.rooms {
+				ns.index.remove(roomKey(ns.endpoint, room), ns)
+			}
+		}
+	}
+	if !c {
+		ns.rooms = make(map[string]bool)
+	}
 	ns.connected = c
+	ns.Unlock()
+
+	if leaving && ns.onLeave != nil {
+		ns.onLeave()
+	}
+}
+
+// Join adds the connection to the rooms, see BroadcastOperator.To. The rooms
+// are left when the namespace disconnects.
+func (ns *NameSpace) Join(rooms ...string) error {
+	ns.Lock()
+	defer ns.Unlock()
+
+	if !ns.connected {
+		return NotConnected
+	}
+	for _, room := range rooms {
+		if ns.rooms[room] {
+			continue
+		}
+		ns.rooms[room] = true
+		if ns.index != nil {
+			ns.index.add(roomKey(ns.endpoint, room), ns)
+		}
+	}
+	return nil
+}
+
+// Leave removes the connection from the rooms.
+func (ns *NameSpace) Leave(rooms ...string) {
+	ns.Lock()
+	defer ns.Unlock()
+
+	for _, room := range rooms {
+		if !ns.rooms[room] {
+			continue
+		}
+		delete(ns.rooms, room)
+		if ns.index != nil {
+			ns.index.remove(roomKey(ns.endpoint, room), ns)
+		}
+	}
+}
+
+// Rooms returns the rooms the connection is in, sorted.
+func (ns *NameSpace) Rooms() []string {
+	ns.Lock()
+	defer ns.Unlock()
+
+	ret := make([]string, 0, len(ns.rooms))
+	for room := range ns.rooms {
+		ret = append(ret, room)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// In reports whether the connection is in room.
+func (ns *NameSpace) In(room string) bool {
+	ns.Lock()
+	defer ns.Unlock()
+
+	return ns.rooms[room]
 }
 
 func (ns *NameSpace) isConnected() bool{
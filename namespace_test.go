@@ -0,0 +1,139 @@
+package netio
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestEmitReliable(t *testing.T) {
+	conn := &broadcastConn{id: "1"}
+	ns := NewNameSpace(conn, "/chat", NewEventEmitter())
+	ns.setConnected(true)
+	errs := []error{}
+	onError := func(err error) {
+		errs = append(errs, err)
+	}
+
+	assert.Equal(t, nil, ns.EmitReliable("news", onError, "hello"))
+	assert.Equal(t, 1, len(conn.written))
+	ns.retransmit(false, time.Minute, 2)
+	assert.Equal(t, 1, len(conn.written))
+	ns.retransmit(true, time.Minute, 2)
+	assert.Equal(t, 2, len(conn.written))
+	assert.Equal(t, string(conn.written[0]), string(conn.written[1]))
+
+	ack := new(ackPacket)
+	ack.ackId = 1
+	ns.onAckPacket(ack)
+	ns.retransmit(true, time.Minute, 2)
+	assert.Equal(t, 2, len(conn.written))
+
+	ns.EmitReliable("news", onError, "again")
+	ns.retransmit(false, 0, 1)
+	assert.Equal(t, 4, len(conn.written))
+	ns.retransmit(false, 0, 1)
+	assert.Equal(t, 4, len(conn.written))
+	assert.Equal(t, []error{DeliveryError}, errs)
+
+	ns.EmitReliable("news", onError, "closed")
+	ns.onDisconnect()
+	assert.Equal(t, []error{DeliveryError, ClosedError}, errs)
+	assert.Equal(t, NotConnected, ns.EmitReliable("news", onError, "late"))
+}
+
+// waitWritten waits for the queue to mark the reliable event id as handed to
+// the transport, which happens after the transport received it.
+func waitWritten(ns *NameSpace, id int) {
+	for i := 0; i < 100; i++ {
+		ns.reliableLock.Lock()
+		msg := ns.reliable[id]
+		written := msg != nil && msg.written
+		ns.reliableLock.Unlock()
+		if written {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEmitReliableRetransmit(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.SetRecoveryTimeout(time.Minute)
+	srv.SetReliableTimeout(50 * time.Millisecond)
+	srv.SetReliableRetries(2)
+	get := func(path string) string {
+		return client.get(path).Body.String()
+	}
+
+	sid := client.handshake()
+	conn := srv.GetSessionManager().Get(sid).(*serverConn)
+	defer conn.Close()
+	poll := "/socket.io/1/xhr-polling/" + sid
+	get(poll)
+
+	failed := make(chan error, 1)
+	conn.Of("").EmitReliable("news", func(err error) {
+		failed <- err
+	}, "hello")
+	event := get(poll)
+	assert.Equal(t, true, strings.HasPrefix(event, `5:1+::{"name":"news"`))
+	waitWritten(conn.Of(""), 1)
+
+	// 会话恢复时重发
+	conn.getCurrent().Close()
+	assert.Equal(t, event, get(poll))
+	waitWritten(conn.Of(""), 1)
+
+	// 传输层写出后重发超时的消息
+	time.Sleep(60 * time.Millisecond)
+	conn.OnRawDispatchRemote(nil)
+	assert.Equal(t, event, get(poll))
+	waitWritten(conn.Of(""), 1)
+
+	time.Sleep(60 * time.Millisecond)
+	conn.OnRawDispatchRemote(nil)
+	select {
+	case err := <-failed:
+		assert.Equal(t, DeliveryError, err)
+	case <-time.After(time.Second):
+		t.Error("delivery did not fail")
+	}
+}
+
+func TestEmitReliableResumeQueued(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.SetRecoveryTimeout(time.Minute)
+	get := func(path string) string {
+		return client.get(path).Body.String()
+	}
+
+	sid := client.handshake()
+	conn := srv.GetSessionManager().Get(sid).(*serverConn)
+	defer conn.Close()
+	poll := "/socket.io/1/xhr-polling/" + sid
+	get(poll)
+
+	// 未写出的消息恢复时不重发
+	conn.getCurrent().Close()
+	conn.Of("").EmitReliable("news", nil, "queued")
+	event := get(poll)
+	assert.Equal(t, true, strings.HasPrefix(event, `5:1+::{"name":"news"`))
+	assert.Equal(t, 1, strings.Count(event, `"name":"news"`))
+}
+
+func TestNameSpaceOnLeave(t *testing.T) {
+	ns := NewNameSpace(&broadcastConn{id: "1"}, "/chat", NewEventEmitter())
+	left := 0
+	ns.onLeave = func() {
+		// 在锁外调用
+		assert.Equal(t, 0, len(ns.Rooms()))
+		left++
+	}
+	ns.setConnected(true)
+	ns.setConnected(false)
+	ns.setConnected(false)
+	assert.Equal(t, 1, left)
+}
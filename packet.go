@@ -54,6 +54,7 @@ func (*disconnectPacket) Type() MessageType {
 type connectPacket struct {
 	packetCommon
 	query string
+	sid   string
 }
 
 func (*connectPacket) Type() MessageType {
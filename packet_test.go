@@ -1,9 +1,15 @@
 package netio
 
 import (
-
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
 	"testing"
+
 	"github.com/bmizerany/assert"
+	"github.com/xjtdy888/runicode"
 )
 
 
@@ -39,3 +45,166 @@ func TestDecodePacket(t *testing.T) {
 		t.Log(index,msg)
 	}
 }
+
+// legacyPacketRegexp, legacyDecodePacket and legacyDecodePayload are the
+// regexp based parser decodePacket and decodePayload must stay compatible with.
+var legacyPacketRegexp = regexp.MustCompile(`^([^:]+):([0-9]+)?(\+)?:([^:]+)?:?(.*)?$`)
+
+func legacyDecodePacket(b []byte) (packet Packet, err error) {
+	b = bytes.Trim(b, "\n \r\t")
+	pieces := legacyPacketRegexp.FindSubmatch(b)
+	if pieces == nil {
+		return nil, InvalidPacket
+	}
+	var tid int
+	tid, err = strconv.Atoi(string(pieces[1]))
+	if err != nil {
+		return
+	}
+	common := packetCommon{}
+	if len(pieces[2]) == 0 {
+		common.id = -1
+	} else {
+		common.id, err = strconv.Atoi(string(pieces[2]))
+		if err != nil {
+			return
+		}
+	}
+	common.ack = string(pieces[3]) == "+"
+	common.endPoint = string(pieces[4])
+	return newPacket(tid, common, pieces[5])
+}
+
+// legacyPanic is the error of legacyDecodePayload for the payloads the legacy
+// parser panicked on.
+type legacyPanic struct {
+	value interface{}
+}
+
+func (p legacyPanic) Error() string {
+	return fmt.Sprintf("panic: %v", p.value)
+}
+
+func legacyDecodePayload(rawbyte []byte) (packets []Packet, err error) {
+	// the legacy parser panics on truncated payloads
+	defer func() {
+		if r := recover(); r != nil {
+			err = legacyPanic{r}
+		}
+	}()
+
+	data := runicode.New(string(rawbyte))
+	sep := runicode.New(string(packetSep))
+	pl := len(sep)
+
+	if len(data) >= pl && data.HasPrefix(sep) {
+		for {
+			if len(data) == 0 {
+				break
+			}
+			data = data[pl:]
+			pos := data.Index(sep)
+			var length int
+			length, err = strconv.Atoi(string(data[0:pos]))
+			if err != nil {
+				return
+			}
+			data = data[pos+pl:]
+			var packet Packet
+			packuni := data[0:length]
+			packet, err = legacyDecodePacket([]byte(packuni.String()))
+			if err != nil {
+				return
+			}
+			packets = append(packets, packet)
+			data = data[length:]
+		}
+		return
+	}
+	var packet Packet
+	packet, err = legacyDecodePacket([]byte(data.String()))
+	if err != nil {
+		return
+	}
+	return []Packet{packet}, nil
+}
+
+// samePackets reports whether a and b have the same type, id, ack, endpoint
+// and data.
+func samePackets(a, b []Packet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		fa, fb := FlattenPacket(a[i]), FlattenPacket(b[i])
+		if !bytes.Equal(fa.Data, fb.Data) || !bytes.Equal(fa.Args, fb.Args) {
+			return false
+		}
+		fa.Data, fb.Data, fa.Args, fb.Args = nil, nil, nil, nil
+		if !reflect.DeepEqual(fa, fb) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkParser compares decodePayload with the legacy parser. Where the legacy
+// parser panicked on a truncated payload, decodePayload returns InvalidPayload.
+func checkParser(t *testing.T, raw []byte) {
+	want, wantErr := legacyDecodePayload(raw)
+	got, err := decodePayload(raw)
+	if _, ok := wantErr.(legacyPanic); ok {
+		wantErr = InvalidPayload
+	}
+	if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+		t.Errorf("%q: error %v, legacy error %v", raw, err, wantErr)
+	}
+	if !samePackets(got, want) {
+		t.Errorf("%q: packets %s, legacy packets %s", raw, describePackets(got), describePackets(want))
+	}
+}
+
+func FuzzDecodePayload(f *testing.F) {
+	for _, sample := range parserSamples {
+		f.Add([]byte(sample.raw))
+	}
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		// the regexp of the legacy parser takes milliseconds on long inputs,
+		// which stalls the minimization
+		if len(raw) > 1024 {
+			return
+		}
+		checkParser(t, raw)
+	})
+}
+
+var benchPayload = []byte("\ufffd59\ufffd" + `5:::{"name":"set_uuid","args":["06dcHVX6la+UWnyOifjEAg=="]}` + "\ufffd67\ufffd" + `5:::{"name":"set_uuid","args":["HR7aU6D72fRLroK3lMesKR9dEizWMV9q"]}`)
+var benchPacket = []byte(`5:12+:/chat:{"name":"set_uuid","args":["06dcHVX6la+UWnyOifjEAg=="]}`)
+
+func BenchmarkDecodePacket(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		decodePayload(benchPacket)
+	}
+}
+
+func BenchmarkLegacyDecodePacket(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		legacyDecodePayload(benchPacket)
+	}
+}
+
+func BenchmarkDecodePayload(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		decodePayload(benchPayload)
+	}
+}
+
+func BenchmarkLegacyDecodePayload(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		legacyDecodePayload(benchPayload)
+	}
+}
@@ -4,14 +4,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
-	"regexp"
 	"strconv"
-	"github.com/xjtdy888/runicode"
+	"unicode/utf8"
 )
 
 var (
-	packetSep    = []byte("\ufffd")
-	packetRegexp = regexp.MustCompile(`^([^:]+):([0-9]+)?(\+)?:([^:]+)?:?(.*)?$`)
+	packetSep = []byte("\ufffd")
+
+	InvalidPacket  = errors.New("invalid packet")
+	InvalidPayload = errors.New("invalid payload")
 )
 
 type Event struct {
@@ -31,6 +32,17 @@ func encodePacket(endpoint string, packet Packet) []byte {
 	}
 	buf.WriteByte(':')
 	buf.WriteString(endpoint)
+
+	// like socket.io 0.9, the packets without data end after the endpoint,
+	// "8::" or "1::/chat"
+	switch p := packet.(type) {
+	case *disconnectPacket, *heartbeatPacket, *noopPacket:
+		return buf.Bytes()
+	case *connectPacket:
+		if p.query == "" {
+			return buf.Bytes()
+		}
+	}
 	buf.WriteByte(':')
 
 	enc := json.NewEncoder(buf)
@@ -80,28 +92,63 @@ func encodePayload(payloads [][]byte) []byte {
 }
 
 func decodePacket(b []byte) (packet Packet, err error) {
-	b = bytes.Trim(b, "\n \r\t")
-	pieces := packetRegexp.FindSubmatch(b)
-	if pieces == nil {
-		return nil, errors.New("invalid packet")
+	b = trimPacket(b)
+
+	// type:id[+]:endpoint[:data], see the legacy regexp
+	// ^([^:]+):([0-9]+)?(\+)?:([^:]+)?:?(.*)?$
+	i := bytes.IndexByte(b, ':')
+	if i <= 0 {
+		return nil, InvalidPacket
+	}
+	typ := b[:i]
+	b = b[i+1:]
+
+	i = 0
+	for i < len(b) && b[i] >= '0' && b[i] <= '9' {
+		i++
+	}
+	id := b[:i]
+	b = b[i:]
+	common := packetCommon{id: -1}
+	if len(b) > 0 && b[0] == '+' {
+		common.ack = true
+		b = b[1:]
+	}
+	if len(b) == 0 || b[0] != ':' {
+		return nil, InvalidPacket
+	}
+	b = b[1:]
+
+	var data []byte
+	i = bytes.IndexByte(b, ':')
+	if i < 0 {
+		common.endPoint = string(b)
+		data = b[len(b):]
+	} else {
+		common.endPoint = string(b[:i])
+		data = b[i+1:]
+		if bytes.IndexByte(data, '\n') >= 0 {
+			return nil, InvalidPacket
+		}
 	}
+
+	// the numbers are converted once the packet matched, as the regexp did
 	var tid int
-	tid, err = strconv.Atoi(string(pieces[1]))
+	tid, err = atoi(typ)
 	if err != nil {
 		return
 	}
-	common := packetCommon{}
-	if len(pieces[2]) == 0 {
-		common.id = -1
-	} else {
-		common.id, err = strconv.Atoi(string(pieces[2]))
+	if len(id) > 0 {
+		common.id, err = atoi(id)
 		if err != nil {
 			return
 		}
 	}
-	common.ack = string(pieces[3]) == "+"
-	common.endPoint = string(pieces[4])
-	data := pieces[5]
+	return newPacket(tid, common, data)
+}
+
+// newPacket returns the packet of type tid with its data.
+func newPacket(tid int, common packetCommon, data []byte) (packet Packet, err error) {
 	switch tid {
 	case 0: // disconnect
 		p := new(disconnectPacket)
@@ -178,43 +225,136 @@ func decodePacket(b []byte) (packet Packet, err error) {
 	return
 }
 
+// decodePayload decodes a packet, or the packets framed as
+// \ufffd<length>\ufffd<packet>, the length counted in UTF-16 code units. The
+// packets may share memory with rawbyte.
 func decodePayload(rawbyte []byte) (packets []Packet, err error) {
-	data := runicode.New(string(rawbyte))
-	sep := runicode.New(string(packetSep))
-	pl := len(sep)
-	
-	if len(data) >= pl && data.HasPrefix(sep) {
-		for {
-			if len(data) == 0 {
-				break
-			}
-			data = data[pl:]
-			pos := data.Index(sep)
-			var length int
-			length, err = strconv.Atoi(string(data[0:pos]))
-			if err != nil {
+	data := rawbyte
+	if !utf8.Valid(data) {
+		// The lengths count the characters the client sent. Like the UTF-16
+		// conversion of the regexp based parser this replaced, the round trip
+		// through []rune turns each invalid byte into one U+FFFD, so a broken
+		// payload is split at the same places it was before.
+		data = []byte(string([]rune(string(data))))
+	}
+
+	if !bytes.HasPrefix(data, packetSep) {
+		var packet Packet
+		packet, err = decodePacket(data)
+		if err != nil {
+			return
+		}
+		return []Packet{packet}, nil
+	}
+
+	// lowHalf is set when the previous length ended in the middle of a
+	// surrogate pair, whose low half then takes the place of the next separator.
+	lowHalf := false
+	for len(data) > 0 || lowHalf {
+		if lowHalf {
+			lowHalf = false
+		} else {
+			// The separator is skipped as a single unit, so the high half of
+			// a character outside the BMP in its place was skipped by the
+			// regexp parser, and the low half made the length invalid.
+			r, size := utf8.DecodeRune(data)
+			data = data[size:]
+			if r >= 0x10000 {
+				pos := bytes.Index(data, packetSep)
+				if pos < 0 {
+					return packets, InvalidPayload
+				}
+				_, err = strconv.Atoi("\ufffd" + splitSurrogates(data[:pos]))
 				return
 			}
-			data = data[pos+pl:]
-			var packet Packet
-			packuni := data[0:length]
-			packet, err = decodePacket([]byte(packuni.String()))
-			if err != nil {
-				return
+		}
+		pos := bytes.Index(data, packetSep)
+		if pos < 0 {
+			return packets, InvalidPayload
+		}
+		var length int
+		length, err = strconv.Atoi(string(data[:pos]))
+		if err != nil {
+			_, err = strconv.Atoi(splitSurrogates(data[:pos]))
+			return
+		}
+		data = data[pos+len(packetSep):]
+
+		offset, units := 0, 0
+		for units < length && offset < len(data) {
+			r, size := utf8.DecodeRune(data[offset:])
+			if r >= 0x10000 {
+				units += 2
+			} else {
+				units++
 			}
-			packets = append(packets, packet)
-			data = data[length:]
+			offset += size
+		}
+		if units < length || length < 0 {
+			return packets, InvalidPayload
+		}
+
+		packuni := data[:offset]
+		if units > length {
+			// The packet ends with the high half of the last character. A lone
+			// surrogate has no UTF-8 encoding and became U+FFFD when the
+			// regexp parser converted the packet back from UTF-16, which is
+			// the separator's bytes; the low half is skipped as the separator.
+			lowHalf = true
+			packuni = append(append([]byte{}, data[:offset-4]...), packetSep...)
 		}
-		return 
-	} else {
 		var packet Packet
-		packstring := data.String()
-		packet, err = decodePacket([]byte(packstring))
+		packet, err = decodePacket(packuni)
 		if err != nil {
 			return
 		}
-		return []Packet{packet}, nil
+		packets = append(packets, packet)
+		data = data[offset:]
 	}
 	return
 }
 
+// splitSurrogates returns b with each character outside the BMP as two U+FFFD,
+// the halves of its surrogate pair, which is how the regexp based parser
+// reported an invalid length.
+func splitSurrogates(b []byte) string {
+	buf := make([]rune, 0, len(b))
+	for _, r := range string(b) {
+		if r >= 0x10000 {
+			buf = append(buf, utf8.RuneError, utf8.RuneError)
+		} else {
+			buf = append(buf, r)
+		}
+	}
+	return string(buf)
+}
+
+// trimPacket trims the whitespaces bytes.Trim(b, "\n \r\t") would.
+func trimPacket(b []byte) []byte {
+	for len(b) > 0 && isPacketSpace(b[0]) {
+		b = b[1:]
+	}
+	for len(b) > 0 && isPacketSpace(b[len(b)-1]) {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+func isPacketSpace(c byte) bool {
+	return c == '\n' || c == ' ' || c == '\r' || c == '\t'
+}
+
+// atoi is strconv.Atoi without converting b to string for small numbers.
+func atoi(b []byte) (int, error) {
+	if len(b) == 0 || len(b) > 9 {
+		return strconv.Atoi(string(b))
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return strconv.Atoi(string(b))
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, nil
+}
@@ -0,0 +1,74 @@
+package netio
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// describePackets prints packets as
+// type:id:ack:endpoint:name:ackid:args:data:advice, one per packet.
+func describePackets(packets []Packet) string {
+	s := []string{}
+	for _, packet := range packets {
+		raw := FlattenPacket(packet)
+		s = append(s, fmt.Sprintf("%d:%d:%t:%s:%s:%d:%s:%s:%s",
+			raw.Type, raw.Id, raw.Ack, raw.EndPoint, raw.Name, raw.AckId, string(raw.Args), raw.Data, raw.Advice))
+	}
+	return strings.Join(s, " | ")
+}
+
+// parserSamples are decoded as the regexp based parser decoded them, which
+// panicked on the truncated payloads.
+var parserSamples = []struct {
+	raw     string
+	packets string
+	err     string
+}{
+	{`5:::{"name":"set_uuid","args":["06dcHVX6la+UWnyOifjEAg=="]}`,
+		`5:-1:false::set_uuid:0:["06dcHVX6la+UWnyOifjEAg=="]::`, ""},
+	{"�59�" + `5:::{"name":"set_uuid","args":["06dcHVX6la+UWnyOifjEAg=="]}` + "�67�" + `5:::{"name":"set_uuid","args":["HR7aU6D72fRLroK3lMesKR9dEizWMV9q"]}`,
+		`5:-1:false::set_uuid:0:["06dcHVX6la+UWnyOifjEAg=="]:: | 5:-1:false::set_uuid:0:["HR7aU6D72fRLroK3lMesKR9dEizWMV9q"]::`, ""},
+	{"0::/chat", "0:-1:false:/chat::0:::", ""},
+	{"1::/chat:?a=b", "1:-1:false:/chat::0::?a=b:", ""},
+	{"2::", "2:-1:false:::0:::", ""},
+	{"3:1::hello world", "3:1:false:::0::hello world:", ""},
+	{`4:2+::{"a":1}`, `4:2:true:::0::{"a":1}:`, ""},
+	{`5:3+:/chat:{"name":"hi","args":[1,"\u4f60\u597d"]}`, `5:3:true:/chat:hi:0:[1,"\u4f60\u597d"]::`, ""},
+	{`6:::3+["ok"]`, `6:-1:false:::3:["ok"]::`, ""},
+	{"7:::reason+advice", "7:-1:false:::0::reason:advice", ""},
+	{"8::", "", "invalid message type"},
+	{"  3:::trimmed \r\n", "3:-1:false:::0::trimmed:", ""},
+	{"3::ep\nx", "3:-1:false:ep\nx::0:::", ""},
+	{"3:::bad\nline", "", "invalid packet"},
+	{"�7�3:::\U0001F600�3�2::", "3:-1:false:::0::\U0001F600�:", `strconv.Atoi: parsing "": invalid syntax`},
+	{"�6�3:::\U0001F600�4�2::", "3:-1:false:::0::\U0001F600:", "invalid payload"},
+	{"�5�3:::\U0001F600\U0001F600", "3:-1:false:::0::�:", "invalid payload"},
+	{"�9�3:::你", "", "invalid payload"},
+	{"�-1�3::", "", "invalid payload"},
+	{"�4�", "", "invalid payload"},
+	{"9:::", "", "invalid message type"},
+	{"x:::", "", `strconv.Atoi: parsing "x": invalid syntax`},
+	{":::", "", "invalid packet"},
+	{"3\xff:::a", "", "strconv.Atoi: parsing \"3�\": invalid syntax"},
+	{"A:0", "", "invalid packet"},
+	{"A:::\n0", "", "invalid packet"},
+	{"\xbd\U0001F600\xef", "", "strconv.Atoi: parsing \"\ufffd\ufffd\": invalid syntax"},
+	{"\ufffd7\ufffd0::0000\U000a8344\ufffd", "0:-1:false:0000::0:::", "strconv.Atoi: parsing \"\ufffd\": invalid syntax"},
+}
+
+func TestParserSamples(t *testing.T) {
+	for _, sample := range parserSamples {
+		packets, err := decodePayload([]byte(sample.raw))
+		if got := describePackets(packets); got != sample.packets {
+			t.Errorf("%q: packets %q, want %q", sample.raw, got, sample.packets)
+		}
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != sample.err {
+			t.Errorf("%q: error %q, want %q", sample.raw, got, sample.err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package polling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+var InvalidIndex = errors.New("invalid jsonp index")
+
+// maxIndexLength bounds the index, io.j of the clients is an array.
+const maxIndexLength = 9
+
+// ParseIndex parses the jsonp callback index of the socket.io 0.9 clients,
+// which must be a decimal number. It is written into javascript, anything else
+// is rejected.
+func ParseIndex(s string) (int, error) {
+	if s == "" || len(s) > maxIndexLength {
+		return 0, InvalidIndex
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, InvalidIndex
+		}
+	}
+	return strconv.Atoi(s)
+}
+
+// WriteJSONP writes data as the argument of the callback index, a javascript
+// string literal.
+func WriteJSONP(w http.ResponseWriter, index int, data []byte) error {
+	// encoding/json 会转义 <, >, &, U+2028 和 U+2029
+	jd, err := json.Marshal(string(data))
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("io.j[%d](%s);", index, jd)
+	w.Header().Set("Content-Type", "text/javascript; charset=UTF-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(message)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, err = w.Write([]byte(message))
+	return err
+}
@@ -0,0 +1,25 @@
+package polling
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestParseIndex(t *testing.T) {
+	for _, index := range []string{"", "0);alert(1)//", "1]", "-1", "1e3", " 1", "0x1", "1234567890", "１"} {
+		_, err := ParseIndex(index)
+		assert.Equal(t, InvalidIndex, err)
+	}
+	index, err := ParseIndex("12")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 12, index)
+}
+
+func TestWriteJSONP(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteJSONP(w, 3, []byte("3:::</script><script>alert(1)</script>\u2028"))
+	assert.Equal(t, `io.j[3]("3:::\u003c/script\u003e\u003cscript\u003ealert(1)\u003c/script\u003e\u2028");`, w.Body.String())
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+}
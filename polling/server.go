@@ -32,7 +32,9 @@ type Polling struct {
 	state       state
 	stateLocker sync.Mutex
 	closeChan   chan bool
-	curreq		*http.Request
+	// getCancel 用来结束正在等待的 GET
+	getCancel   chan struct{}
+	cancelLocker sync.Mutex
 }
 
 func NewServer(w http.ResponseWriter, r *http.Request, callback transport.Callback) (transport.Server, error) {
@@ -47,12 +49,8 @@ func NewServer(w http.ResponseWriter, r *http.Request, callback transport.Callba
 }
 
 func (p *Polling) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("origin") != "" {
-		// https://developer.mozilla.org/En/HTTP_Access_Control
-		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("origin"))
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-	}
+	// CORS 由 netio.Server 统一处理
+	w.Header().Set("X-Content-Type-Options", "nosniff")
 	switch r.Method {
 	case "GET":
 		p.get(w, r)
@@ -79,18 +77,23 @@ func (p *Polling) Close() error {
 }
 
 func (p *Polling) get(w http.ResponseWriter, r *http.Request) {
-	if !p.getLocker.TryLock() {
-		//http.Error(w, "8:::::overlay get", http.StatusBadRequest)
-		<- time.After(1 * time.Second)
-		addr, uri := "-", "-"
-		if p.curreq != nil {
-			addr, uri = p.curreq.RemoteAddr, p.curreq.URL.RequestURI()
+	jsonp := strings.Contains(r.RequestURI, "/jsonp-polling/")
+	index := 0
+	if jsonp {
+		var err error
+		if index, err = ParseIndex(r.URL.Query().Get("i")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		fmt.Fprintf(w, "8:::::overlay get [%s] (%s)", addr, uri)
+	}
+	cancel, locked := p.lockGet(r)
+	if !locked {
+		// a newer GET overlapped while this one waited for the lock
+		p.write(w, r, jsonp, index, p.callback.Noop())
 		return
 	}
-	p.curreq = r
 	if p.getState() != stateNormal {
+		p.getLocker.Unlock()
 		http.Error(w, "closed", http.StatusForbidden)
 		return
 	}
@@ -103,10 +106,24 @@ func (p *Polling) get(w http.ResponseWriter, r *http.Request) {
 				p.postLocker.Unlock()
 			}
 		}
+		p.cancelLocker.Lock()
+		if p.getCancel == cancel {
+			p.getCancel = nil
+		}
+		p.cancelLocker.Unlock()
 		p.getLocker.Unlock()
 	}()
 
-	closeNotifier, _ := w.(http.CloseNotifier)
+	var closeNotify <-chan bool
+	if closeNotifier, ok := w.(http.CloseNotifier); ok {
+		closeNotify = closeNotifier.CloseNotify()
+	}
+	var timeout <-chan time.Time
+	if d := p.callback.PollingTimeout(); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
 	senderChan := p.callback.SenderChan()
 	var data []byte
 	
@@ -117,29 +134,28 @@ func (p *Polling) get(w http.ResponseWriter, r *http.Request) {
 				data = node
 			}
 		}
-	case <-closeNotifier.CloseNotify():
+		p.callback.OnRawDispatchRemote(data)
+	case <-timeout:
+		data = p.callback.Noop()
+	case <-cancel:
+		data = p.callback.Noop()
+	case <-closeNotify:
 		log.Debugf("[%s] CloseNotifier ", r.URL.Path)
 		return 
 	case <-p.closeChan:
 
 		return
 	}
+	p.write(w, r, jsonp, index, data)
+}
 
-	p.callback.OnRawDispatchRemote(data)
-
-	if strings.Contains(r.RequestURI, "/jsonp-polling/") {
-		index := r.URL.Query().Get("i")
-		jd, err := json.Marshal(string(data))
-		if err != nil {
-			log.Errorf("[%s] json.Marshal error [%s]", r.URL.Path, err)
-			return 
+// write answers a GET with data.
+func (p *Polling) write(w http.ResponseWriter, r *http.Request, jsonp bool, index int, data []byte) {
+	if jsonp {
+		w.Header().Set("Connection", "Keep-Alive")
+		if err := WriteJSONP(w, index, data); err != nil {
+			log.Errorf("[%s] write jsonp error [%s]", r.URL.Path, err)
 		}
-		message := fmt.Sprintf("io.j[%s](%s)", index, string(jd))
-		w.Header().Set("Content-Type", "text/javascript; charset=UTF-8")
-        w.Header().Set("Content-Length", fmt.Sprintf("%d", len(message)))
-        w.Header().Set("X-XSS-Protection", "0")
-        w.Header().Set("Connection", "Keep-Alive")
-		w.Write([]byte(message))
 	} else {
 		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
@@ -148,6 +164,23 @@ func (p *Polling) get(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// lockGet takes the GET lock. The newest GET always wins: it cancels the
+// previous one, waiting for data or for the lock, which is answered with a
+// noop. The returned channel is closed when the next GET overlaps, and lockGet
+// reports false if that happens before the lock is taken.
+func (p *Polling) lockGet(r *http.Request) (chan struct{}, bool) {
+	cancel := make(chan struct{})
+	p.cancelLocker.Lock()
+	if p.getCancel != nil {
+		log.Debugf("[%s] overlapping get", r.URL.Path)
+		close(p.getCancel)
+	}
+	p.getCancel = cancel
+	p.cancelLocker.Unlock()
+
+	return cancel, p.getLocker.LockCancel(cancel)
+}
+
 func (p *Polling) post(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	if !p.postLocker.TryLock() {
@@ -15,6 +15,9 @@ import (
 	"github.com/googollee/go-engine.io/parser"
 	"github.com/googollee/go-engine.io/transport"
 	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/bmizerany/assert"
+	iotransport "github.com/xjtdy888/netio/transport"
 )
 
 func TestServer(t *testing.T) {
@@ -505,3 +508,102 @@ func (f *fakeCallback) ClosedCount() int {
 	defer f.countLocker.Unlock()
 	return f.closedCount
 }
+
+// pollingCallback is the callback of TestPollingTimeout. If waiting is set, it
+// is signalled when a GET waits for the sender. If noops is set, it is
+// signalled when a GET is answered with a noop, which then waits for gate.
+type pollingCallback struct {
+	sender  chan []byte
+	waiting chan struct{}
+	noops   chan struct{}
+	gate    chan struct{}
+	timeout time.Duration
+}
+
+func (c *pollingCallback) SenderChan() chan []byte {
+	if c.waiting != nil {
+		c.waiting <- struct{}{}
+	}
+	return c.sender
+}
+
+func (c *pollingCallback) Noop() []byte {
+	if c.noops != nil {
+		c.noops <- struct{}{}
+		<-c.gate
+	}
+	return []byte("8::")
+}
+
+func (c *pollingCallback) OnRawMessage(data []byte)          {}
+func (c *pollingCallback) OnRawDispatchRemote(data []byte)   {}
+func (c *pollingCallback) OnClose(server iotransport.Server) {}
+func (c *pollingCallback) PollingTimeout() time.Duration     { return c.timeout }
+
+func TestPollingTimeout(t *testing.T) {
+	callback := &pollingCallback{sender: make(chan []byte), timeout: 50 * time.Millisecond}
+	p, _ := NewServer(nil, nil, callback)
+	get := func() string {
+		r, _ := http.NewRequest("GET", "/socket.io/1/xhr-polling/abc", nil)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	start := time.Now()
+	assert.Equal(t, "8::", get())
+	assert.Equal(t, true, time.Since(start) >= 50*time.Millisecond)
+
+	go func() {
+		callback.sender <- []byte("3:::hello")
+	}()
+	assert.Equal(t, "3:::hello", get())
+
+	// 重叠的 GET 让之前的 GET 立即返回 noop
+	callback = &pollingCallback{sender: make(chan []byte), waiting: make(chan struct{})}
+	p, _ = NewServer(nil, nil, callback)
+	done := make(chan string, 2)
+	go func() {
+		done <- "first " + get()
+	}()
+	<-callback.waiting
+	go func() {
+		done <- "second " + get()
+	}()
+	assert.Equal(t, "first 8::", <-done)
+	<-callback.waiting
+	callback.sender <- []byte("3:::hello")
+	assert.Equal(t, "second 3:::hello", <-done)
+
+	// 第三个 GET 在第二个等待锁时重叠, 最新的 GET 接管
+	callback = &pollingCallback{
+		sender:  make(chan []byte),
+		waiting: make(chan struct{}),
+		noops:   make(chan struct{}),
+		gate:    make(chan struct{}),
+	}
+	p, _ = NewServer(nil, nil, callback)
+	done = make(chan string, 3)
+	go func() {
+		done <- "first " + get()
+	}()
+	<-callback.waiting
+	go func() {
+		done <- "second " + get()
+	}()
+	// the first GET answers the noop holding the lock, the second waits for it
+	<-callback.noops
+	go func() {
+		done <- "third " + get()
+	}()
+	<-callback.noops
+	close(callback.gate)
+	noops := []string{<-done, <-done}
+	assert.Equal(t, true, noops[0] != noops[1])
+	for _, noop := range noops {
+		assert.Equal(t, true, noop == "first 8::" || noop == "second 8::")
+	}
+	<-callback.waiting
+	callback.sender <- []byte("3:::hello")
+	assert.Equal(t, "third 3:::hello", <-done)
+}
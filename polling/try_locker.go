@@ -14,6 +14,17 @@ func (l *Locker) Lock() {
 	l.locker <- struct{}{}
 }
 
+// LockCancel takes the lock, unless cancel is closed first. It reports whether
+// the lock was taken.
+func (l *Locker) LockCancel(cancel <-chan struct{}) bool {
+	select {
+	case l.locker <- struct{}{}:
+		return true
+	case <-cancel:
+		return false
+	}
+}
+
 func (l *Locker) TryLock() bool {
 	select {
 	case l.locker <- struct{}{}:
@@ -0,0 +1,168 @@
+package netio
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xjtdy888/netio/polling"
+	"github.com/xjtdy888/netio/transport"
+)
+
+// protocol is one wire revision spoken with clients: the handshake, the packet
+// codec and the payload framing of a connection.
+type protocol interface {
+	// handshake answers the handshake request of the new connection c.
+	handshake(s *Server, c *serverConn, req *IORequest, w http.ResponseWriter, r *http.Request)
+
+	encodePacket(endpoint string, packet Packet) []byte
+	decodePayload(data []byte) ([]Packet, error)
+
+	// encodePayload frames queued packets for one write on transportName and
+	// returns how many of them it took.
+	encodePayload(transportName string, packets [][]byte) ([]byte, int)
+
+	// serverPings reports whether the server sends the heartbeats. Otherwise
+	// the client does and the server answers them.
+	serverPings() bool
+
+	// autoConnect reports whether the default namespace is connected by the
+	// handshake, without waiting for a connect packet from the client.
+	autoConnect() bool
+
+	// ackConnect reports whether a namespace connecting is confirmed to the
+	// client with a connect packet.
+	ackConnect() bool
+
+	// probe handles a message received on a transport probed as upgrade
+	// target. It reports whether the upgrade is confirmed and whether data
+	// was consumed by the probe.
+	probe(c *serverConn, t transport.Server, data []byte) (upgrade bool, consumed bool)
+}
+
+// socket.io 0.9 的协议版本号
+const v1ProtocolVersion = 1
+
+// ProtocolHandler serves the handshakes and the transports of a protocol
+// version which is not built in, see RegisterProtocol.
+type ProtocolHandler interface {
+	ServeIO(req *IORequest, w http.ResponseWriter, r *http.Request)
+}
+
+// ProtocolHandlerFunc is a func used as ProtocolHandler.
+type ProtocolHandlerFunc func(req *IORequest, w http.ResponseWriter, r *http.Request)
+
+func (f ProtocolHandlerFunc) ServeIO(req *IORequest, w http.ResponseWriter, r *http.Request) {
+	f(req, w, r)
+}
+
+// protocolKey is the version of the url, /{resource}/{version}/, or the EIO
+// query parameter.
+type protocolKey struct {
+	eio     bool
+	version int
+}
+
+// RegisterProtocol dispatches the requests to /{resource}/{version}/ to h.
+// It takes precedence over the built in socket.io 0.9, version 1. It must be
+// called before serving.
+func (s *Server) RegisterProtocol(version int, h ProtocolHandler) {
+	s.protocols[protocolKey{false, version}] = h
+}
+
+// RegisterEIOProtocol dispatches the requests of the engine.io clients with
+// EIO=version to h. It takes precedence over the built in versions 3 and 4.
+// It must be called before serving.
+func (s *Server) RegisterEIOProtocol(version int, h ProtocolHandler) {
+	s.protocols[protocolKey{true, version}] = h
+}
+
+// protocolHandler returns the handler registered for the version of req.
+func (s *Server) protocolHandler(req *IORequest) ProtocolHandler {
+	if req.EIO > 0 {
+		return s.protocols[protocolKey{true, req.EIO}]
+	}
+	return s.protocols[protocolKey{false, req.Protocol}]
+}
+
+// unsupportedProtocol rejects req with 400.
+func (s *Server) unsupportedProtocol(req *IORequest, w http.ResponseWriter) {
+	reason := UnsupportedProtocol.Error()
+	if req.EIO > 0 {
+		reason = "Unsupported protocol version"
+	}
+	s.reject(req, w, http.StatusBadRequest, 5, reason)
+}
+
+// protocolFor returns the protocol of the request, nil if not supported.
+func (s *Server) protocolFor(req *IORequest) protocol {
+	switch req.EIO {
+	case 0:
+		if req.Protocol == v1ProtocolVersion {
+			return s.protoV1
+		}
+	case 3:
+		return eioV3
+	case 4:
+		return eioV4
+	}
+	return nil
+}
+
+// v1Protocol is the socket.io 0.9 protocol, served under /{resource}/1/.
+type v1Protocol struct {
+	codec Codec
+}
+
+// defaultProtocol is socket.io 0.9 with TextCodec.
+var defaultProtocol protocol = &v1Protocol{TextCodec}
+
+func (v1Protocol) handshake(s *Server, c *serverConn, req *IORequest, w http.ResponseWriter, r *http.Request) {
+	transports := s.advertisedTransports()
+
+	data := fmt.Sprintf("%s:%d:%d:%s",
+		c.Id(),
+		s.config.PingInterval/time.Second,
+		s.config.PollingTimeout/time.Second,
+		strings.Join(transports, ","))
+
+	if jsonp := r.URL.Query().Get("jsonp"); jsonp != "" {
+		// checked by handleHandshake
+		index, _ := polling.ParseIndex(jsonp)
+		polling.WriteJSONP(w, index, []byte(data))
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		fmt.Fprintf(w, "%s", data)
+	}
+	c.onOpen()
+}
+
+func (p v1Protocol) encodePacket(endpoint string, packet Packet) []byte {
+	return p.codec.EncodePacket(endpoint, packet)
+}
+
+func (p v1Protocol) decodePayload(data []byte) ([]Packet, error) {
+	return p.codec.DecodePayload(data)
+}
+
+func (p v1Protocol) encodePayload(transportName string, packets [][]byte) ([]byte, int) {
+	return p.codec.EncodePayload(packets), len(packets)
+}
+
+func (v1Protocol) serverPings() bool {
+	return true
+}
+
+func (v1Protocol) autoConnect() bool {
+	return true
+}
+
+func (v1Protocol) ackConnect() bool {
+	return false
+}
+
+func (v1Protocol) probe(c *serverConn, t transport.Server, data []byte) (bool, bool) {
+	return true, false
+}
@@ -0,0 +1,51 @@
+package netio
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestProtocolVersion(t *testing.T) {
+	srv, client := newTestServer(t)
+	for _, path := range []string{"/socket.io/2/", "/socket.io/0/", "/socket.io/", "/socket.io/2/xhr-polling/abc"} {
+		w := client.get(path)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "7:::unsupported protocol version", w.Body.String())
+	}
+	w := client.get("/socket.io/?EIO=2&transport=polling")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"code":5,"message":"Unsupported protocol version"}`, w.Body.String())
+	assert.Equal(t, 0, srv.SessionCount())
+
+	served := []string{}
+	srv.RegisterProtocol(2, ProtocolHandlerFunc(func(req *IORequest, w http.ResponseWriter, r *http.Request) {
+		served = append(served, req.Transport+"/"+req.Sid)
+	}))
+	srv.RegisterEIOProtocol(2, ProtocolHandlerFunc(func(req *IORequest, w http.ResponseWriter, r *http.Request) {
+		served = append(served, "eio:"+req.Transport)
+	}))
+	client.get("/socket.io/2/")
+	client.get("/socket.io/2/xhr-polling/abc")
+	client.get("/socket.io/?EIO=2&transport=polling")
+	assert.Equal(t, []string{"/", "xhr-polling/abc", "eio:xhr-polling"}, served)
+}
+
+// upperCodec is TextCodec writing upper case packets.
+type upperCodec struct {
+	Codec
+}
+
+func (c upperCodec) EncodePacket(endpoint string, packet Packet) []byte {
+	return bytes.ToUpper(c.Codec.EncodePacket(endpoint, packet))
+}
+
+func TestRejectCodec(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.SetCodec(upperCodec{TextCodec})
+	w := client.get("/socket.io/2/")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "7:::UNSUPPORTED PROTOCOL VERSION", w.Body.String())
+}
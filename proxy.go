@@ -0,0 +1,171 @@
+package netio
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SetTrustedProxies sets the addresses of the reverse proxies, as CIDRs or
+// single IPs. The Forwarded, X-Forwarded-For, X-Forwarded-Proto and X-Real-IP
+// headers are only read from these hops. Default is none, the client is the
+// remote address of the request.
+func (s *Server) SetTrustedProxies(cidrs []string) error {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return &net.ParseError{Type: "IP address", Text: cidr}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		nets = append(nets, ipnet)
+	}
+	s.config.TrustedProxies = nets
+	return nil
+}
+
+func (s *Server) trusted(ip net.IP) bool {
+	for _, ipnet := range s.config.TrustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHop is a client of a proxy, and the scheme it used.
+type forwardedHop struct {
+	ip    net.IP
+	proto string
+}
+
+// ClientAddr returns the ip and the scheme, "http" or "https", of the client of
+// r. The forwarding headers are followed from the remote address back to the
+// first hop which is not a trusted proxy.
+func (s *Server) ClientAddr(r *http.Request) (ip string, scheme string) {
+	scheme = "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	client := parseHopIP(r.RemoteAddr)
+	if client == nil {
+		return r.RemoteAddr, scheme
+	}
+	if !s.trusted(client) {
+		return client.String(), scheme
+	}
+
+	hops := forwardedHops(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i].ip == nil {
+			// unknown 或者混淆过的地址, 无法继续往前追溯
+			break
+		}
+		client = hops[i].ip
+		if hops[i].proto != "" {
+			scheme = hops[i].proto
+		}
+		if !s.trusted(client) {
+			break
+		}
+	}
+	return client.String(), scheme
+}
+
+// forwardedHops returns the hops of the forwarding headers, the client first.
+// Forwarded takes precedence over X-Forwarded-For, then X-Real-IP.
+func forwardedHops(h http.Header) []forwardedHop {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		return parseForwarded(values)
+	}
+
+	var hops []forwardedHop
+	if values := h.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, value := range values {
+			for _, addr := range strings.Split(value, ",") {
+				hops = append(hops, forwardedHop{ip: parseHopIP(addr)})
+			}
+		}
+	} else if addr := h.Get("X-Real-IP"); addr != "" {
+		hops = append(hops, forwardedHop{ip: parseHopIP(addr)})
+	}
+	if len(hops) == 0 {
+		return nil
+	}
+
+	var protos []string
+	for _, value := range h.Values("X-Forwarded-Proto") {
+		for _, proto := range strings.Split(value, ",") {
+			protos = append(protos, normalizeProto(proto))
+		}
+	}
+	switch {
+	case len(protos) == len(hops):
+		for i := range hops {
+			hops[i].proto = protos[i]
+		}
+	case len(protos) > 0:
+		// 代理之间透传的单个值, 是客户端的 scheme
+		for i := range hops {
+			hops[i].proto = protos[0]
+		}
+	}
+	return hops
+}
+
+// parseForwarded parses the for and proto parameters of RFC 7239 headers.
+func parseForwarded(values []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := forwardedHop{}
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				val := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+				switch strings.ToLower(kv[0]) {
+				case "for":
+					hop.ip = parseHopIP(val)
+				case "proto":
+					hop.proto = normalizeProto(val)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHopIP parses "ip", "ip:port", "[ipv6]" and "[ipv6]:port", nil if addr is
+// not an ip.
+func parseHopIP(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.ParseIP(addr)
+}
+
+func normalizeProto(proto string) string {
+	switch strings.ToLower(strings.TrimSpace(proto)) {
+	case "https", "wss":
+		return "https"
+	case "http", "ws":
+		return "http"
+	}
+	return ""
+}
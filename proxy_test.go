@@ -0,0 +1,51 @@
+package netio
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestClientAddr(t *testing.T) {
+	srv, _ := NewServer(nil)
+	newRequest := func(remote string, headers map[string]string) *http.Request {
+		r, _ := http.NewRequest("GET", "/socket.io/1/", nil)
+		r.RemoteAddr = remote
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+
+	spoofed := newRequest("203.0.113.9:5000", map[string]string{"X-Forwarded-For": "1.1.1.1", "X-Forwarded-Proto": "https"})
+	ip, scheme := srv.ClientAddr(spoofed)
+	assert.Equal(t, "203.0.113.9", ip)
+	assert.Equal(t, "http", scheme)
+
+	if err := srv.SetTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}); err != nil {
+		t.Error(err)
+	}
+	assert.NotEqual(t, nil, srv.SetTrustedProxies([]string{"10.0.0.300"}))
+
+	ip, scheme = srv.ClientAddr(spoofed)
+	assert.Equal(t, "203.0.113.9", ip)
+
+	ip, scheme = srv.ClientAddr(newRequest("10.0.0.2:80", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.7, 192.168.1.1", "X-Forwarded-Proto": "https"}))
+	assert.Equal(t, "198.51.100.7", ip)
+	assert.Equal(t, "https", scheme)
+
+	ip, scheme = srv.ClientAddr(newRequest("10.0.0.2:80", map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https, for=10.1.2.3;proto=http`, "X-Forwarded-For": "1.1.1.1"}))
+	assert.Equal(t, "2001:db8::1", ip)
+	assert.Equal(t, "https", scheme)
+
+	ip, _ = srv.ClientAddr(newRequest("10.0.0.2:80", map[string]string{"Forwarded": "for=unknown, for=10.1.2.3"}))
+	assert.Equal(t, "10.1.2.3", ip)
+
+	ip, _ = srv.ClientAddr(newRequest("10.0.0.2:80", map[string]string{"X-Real-IP": "198.51.100.8"}))
+	assert.Equal(t, "198.51.100.8", ip)
+
+	handshake := srv.handshakeData("1", &IORequest{Request: spoofed, Headers: spoofed.Header})
+	assert.Equal(t, "203.0.113.9", handshake.Address)
+	assert.Equal(t, false, handshake.Secure)
+}
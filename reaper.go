@@ -0,0 +1,74 @@
+package netio
+
+import (
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// SetHandshakeTimeout sets how long a session may wait for its first transport
+// after the handshake. The sessions which do not connect in time are closed.
+// Default is 30s, 0 never closes them.
+func (s *Server) SetHandshakeTimeout(t time.Duration) {
+	s.config.HandshakeTimeout = t
+}
+
+// startReaper starts reapHandshakes, unless it is running.
+func (s *Server) startReaper() {
+	s.reaperLocker.Lock()
+	defer s.reaperLocker.Unlock()
+	if s.reaping {
+		return
+	}
+	s.reaping = true
+	go s.reapHandshakes()
+}
+
+// reapHandshakes closes the sessions whose transport did not connect in time.
+// It is started by a handshake, and stops once no handshake is pending.
+func (s *Server) reapHandshakes() {
+	interval := time.Second
+	if timeout := s.config.HandshakeTimeout; timeout > 0 && timeout/2 < interval {
+		interval = timeout / 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if s.reap(now) > 0 {
+			continue
+		}
+		s.reaperLocker.Lock()
+		// checked under the lock of startReaper, a handshake added meanwhile
+		// is never left without a reaper
+		if s.handshaking.Size() == 0 {
+			s.reaping = false
+			s.reaperLocker.Unlock()
+			return
+		}
+		s.reaperLocker.Unlock()
+	}
+}
+
+// reap returns the number of handshakes still pending.
+func (s *Server) reap(now time.Time) int {
+	timeout := s.config.HandshakeTimeout
+	pending := 0
+	for item := range s.handshaking.IterItems() {
+		c := item.Value.(*serverConn)
+		if c.getCurrent() != nil || c.getState() != stateNormal {
+			// 已经连接或者已经关闭
+			s.handshaking.Delete(item.Key)
+			continue
+		}
+		if timeout > 0 && now.Sub(c.handshake.at) > timeout {
+			log.Infof("[%s] no transport %s after handshake, closing", c.Id(), timeout)
+			s.handshaking.Delete(item.Key)
+			s.stats.HandshakeExpired()
+			c.Close()
+			continue
+		}
+		pending++
+	}
+	s.stats.SetPendingHandshakes(pending)
+	return pending
+}
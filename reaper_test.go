@@ -0,0 +1,57 @@
+package netio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestHandshakeTimeout(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.SetHandshakeTimeout(10 * time.Second)
+	client.handshake()
+	assert.Equal(t, 1, srv.SessionCount())
+
+	srv.reap(time.Now())
+	assert.Equal(t, 1, srv.Stats().Dump().PendingHandshakes)
+	assert.Equal(t, 1, srv.SessionCount())
+
+	srv.reap(time.Now().Add(time.Minute))
+	for i := 0; i < 100 && srv.SessionCount() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, srv.SessionCount())
+	stats := srv.Stats().Dump()
+	assert.Equal(t, 0, stats.PendingHandshakes)
+	assert.Equal(t, int64(1), stats.ExpiredHandshakes)
+}
+
+func TestHandshakeTimeoutShort(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.SetHandshakeTimeout(200 * time.Millisecond)
+	client.handshake()
+	assert.Equal(t, 1, srv.SessionCount())
+
+	// 不足一秒的超时也要生效
+	srv.reap(time.Now().Add(100 * time.Millisecond))
+	assert.Equal(t, 1, srv.SessionCount())
+	for i := 0; i < 100 && srv.SessionCount() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, srv.SessionCount())
+
+	// 没有等待中的握手，回收协程退出
+	reaping := func() bool {
+		srv.reaperLocker.Lock()
+		defer srv.reaperLocker.Unlock()
+		return srv.reaping
+	}
+	for i := 0; i < 100 && reaping(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, false, reaping())
+
+	client.handshake()
+	assert.Equal(t, true, reaping())
+}
@@ -0,0 +1,101 @@
+package netio
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	ForeignPath         = errors.New("not found")
+	UnsupportedProtocol = errors.New("unsupported protocol version")
+)
+
+// SetMountPath sets the path the resource is served under, e.g. "/api/realtime"
+// serves /api/realtime/{resource}/1/. Default is "/".
+func (s *Server) SetMountPath(path string) {
+	s.config.MountPath = cleanMountPath(path)
+}
+
+// Handler returns the server mounted under prefix, which is stripped before the
+// path is parsed, e.g. mux.Handle("/api/", srv.Handler("/api")). The mount
+// path set by SetMountPath is relative to prefix.
+func (s *Server) Handler(prefix string) http.Handler {
+	return http.StripPrefix(strings.TrimSuffix(prefix, "/"), s)
+}
+
+func cleanMountPath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "/"
+	}
+	return "/" + path + "/"
+}
+
+// checkRequest parses the url of r:
+//
+//	{mount}{resource}/?EIO=4&transport=polling&sid=...  engine.io clients
+//	{mount}{resource}/1/                                socket.io 0.9 handshake
+//	{mount}{resource}/1/{transport}/{sid}               socket.io 0.9 transports
+//
+// It returns ForeignPath if r is not for the server.
+func (s *Server) checkRequest(r *http.Request) (*IORequest, error) {
+	path := r.URL.Path
+	if !strings.HasPrefix(path, s.config.MountPath) {
+		return nil, ForeignPath
+	}
+	path = path[len(s.config.MountPath):]
+	resource := s.config.ResourceName
+	if path != resource && !strings.HasPrefix(path, resource+"/") {
+		return nil, ForeignPath
+	}
+	path = strings.TrimSuffix(strings.TrimPrefix(path[len(resource):], "/"), "/")
+	var segments []string
+	if path != "" {
+		segments = strings.Split(path, "/")
+	}
+
+	req := &IORequest{
+		Query:     r.URL.Query(),
+		Headers:   r.Header,
+		Request:   r,
+		Path:      r.URL.Path,
+		Namespace: resource,
+	}
+
+	if eio, _ := strconv.Atoi(req.Query.Get("EIO")); eio > 0 {
+		if len(segments) > 0 {
+			return nil, ForeignPath
+		}
+		req.EIO = eio
+		req.Transport = req.Query.Get("transport")
+		if req.Transport == "polling" {
+			req.Transport = "xhr-polling"
+		}
+		req.Sid = req.Query.Get("sid")
+		return req, nil
+	}
+
+	if len(segments) == 0 {
+		// 没有版本号, 由 ServeHTTP 拒绝
+		return req, nil
+	}
+	if len(segments) > 3 || len(segments) == 2 {
+		return nil, ForeignPath
+	}
+	if segments[0] == "" {
+		return nil, ForeignPath
+	}
+	for _, c := range segments[0] {
+		if c < '0' || c > '9' {
+			return nil, ForeignPath
+		}
+	}
+	req.Protocol, _ = strconv.Atoi(segments[0])
+	if len(segments) == 3 {
+		req.Transport = segments[1]
+		req.Sid = segments[2]
+	}
+	return req, nil
+}
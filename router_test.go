@@ -0,0 +1,61 @@
+package netio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestCheckRequest(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.SetMountPath("/api/realtime/")
+	check := func(path string) (*IORequest, error) {
+		r, _ := http.NewRequest("GET", path, nil)
+		return srv.checkRequest(r)
+	}
+
+	req, err := check("/api/realtime/socket.io/1/")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, req.Protocol)
+	assert.Equal(t, "", req.Sid)
+	req, _ = check("/api/realtime/socket.io/1/xhr-polling/abc?t=1")
+	assert.Equal(t, "xhr-polling", req.Transport)
+	assert.Equal(t, "abc", req.Sid)
+	req, _ = check("/api/realtime/socket.io/?EIO=4&transport=polling&sid=abc")
+	assert.Equal(t, 4, req.EIO)
+	assert.Equal(t, "xhr-polling", req.Transport)
+	assert.Equal(t, "abc", req.Sid)
+
+	for _, path := range []string{
+		"/socket.io/1/",
+		"/api/realtime/net.io/1/",
+		"/api/realtime/socket.iox/1/",
+		"/api/realtime/socket.io/x/",
+		"/api/realtime/socket.io/1/xhr-polling/",
+		"/api/realtime/socket.io/1/xhr-polling/abc/def",
+		"/api/realtime/socket.io/1/?EIO=4",
+	} {
+		_, err = check(path)
+		assert.Equal(t, ForeignPath, err)
+	}
+	req, err = check("/api/realtime/socket.io/2/")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, req.Protocol)
+
+	srv.SetMountPath("")
+	mux := http.NewServeMux()
+	mux.Handle("/api/", srv.Handler("/api"))
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/socket.io/1/", strings.NewReader(""))
+	mux.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	srv.GetSessionManager().Get(strings.Split(w.Body.String(), ":")[0]).Close()
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/api/other/1/", strings.NewReader(""))
+	mux.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -1,19 +1,19 @@
 package netio
 
 import (
-	"crypto/md5"
 	"fmt"
-	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
-	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/xjtdy888/netio/polling"
+	"github.com/xjtdy888/netio/syncmap"
 	
 	//"github.com/kr/pretty"
-	"github.com/xjtdy888/netio/polling"
 //log "github.com/cihub/seelog"
-	"github.com/xjtdy888/netio/websocket"
 )
 
 type config struct {
@@ -26,6 +26,26 @@ type config struct {
 	Cookie         string
 	NewId          func(r *http.Request) string
 	ResourceName      string
+	RecoveryTimeout time.Duration
+	ReliableTimeout time.Duration
+	ReliableRetries int
+	Codec           Codec
+	BroadcastWorkers int
+	NodeId          string
+	ClusterTimeout  time.Duration
+	DataStore       DataStore
+	TrustedProxies  []*net.IPNet
+	IdSecret        []byte
+	IdMaxAge        time.Duration
+	IdRouter        func(node string, w http.ResponseWriter, r *http.Request)
+	SessionBinding  *SessionBinding
+	SecurityHandler func(SecurityEvent)
+	CORS            *CORSOptions
+	AllowJSONP      bool
+	MountPath       string
+	HandshakeTimeout time.Duration
+	Limits          ConnectionLimits
+	Authenticator   Authenticator
 }
 
 type IORequest struct {
@@ -35,6 +55,7 @@ type IORequest struct {
 	Path      string
 	Namespace string
 	Protocol  int
+	EIO       int
 	Transport string
 	Sid       string
 }
@@ -47,11 +68,13 @@ type Handshake struct {
 	Headers   http.Header	`json:"headers"`
 	Address   string		`json:"address"`
 	Time      int64			`json:"time"`
-	Query     string		`json:"query"`
+	Query     url.Values	`json:"query"`
 	Url       string		`json:"url"`
 	Xdomain   bool			`json:"xdomain"`
-	Secure    string		`json:"secure"`
+	Secure    bool			`json:"secure"`
 	issued    bool	
+	// at is the time of the handshake, Time has only a one second resolution
+	at        time.Time
 }
 
 // Server is the server of engine.io.
@@ -63,26 +86,31 @@ type Server struct {
 	transportNames		[]string
 	currentConnection int32
 	stats             *StatsCollector
-	eventEmitters    map[string]*EventEmitter
-}
-
-// NewServer returns the server suppported given transports. If transports is nil, server will use ["polling", "websocket"] as default.
+	eventEmitters    map[string]*Endpoint
+	emittersLocker   sync.RWMutex
+	protoV1          protocol
+	protocols        map[protocolKey]ProtocolHandler
+	endpointIndex    *endpointIndex
+	handshaking      *syncmap.SyncMap
+	reaperLocker     sync.Mutex
+	reaping          bool
+	cluster          *cluster
+	clusterLocker    sync.Mutex
+}
+
+// NewServer returns the server suppported given transports. If transports is nil, server will use ["xhr-polling", "jsonp-polling", "websocket", "sse"] as default.
+// Any transport added by RegisterTransport can be named, e.g. "htmlfile" or "flashsocket".
 func NewServer(transports []string) (*Server, error) {
 	if transports == nil {
-		transports = []string{"xhr-polling","jsonp-polling","websocket"}
+		transports = []string{"xhr-polling","jsonp-polling","websocket","sse"}
 	}
 	creaters := make(transportCreaters)
 	for _, t := range transports {
-		switch t {
-		case "xhr-polling":
-			creaters[t] = polling.XHRCreater
-		case "jsonp-polling":
-			creaters[t] = polling.JSONPCreater
-		case "websocket":
-			creaters[t] = websocket.Creater
-		default:
+		creater, ok := lookupTransport(t)
+		if !ok {
 			return nil, InvalidError
 		}
+		creaters[t] = creater
 	}
 	
 	srv := &Server{
@@ -96,39 +124,32 @@ func NewServer(transports []string) (*Server, error) {
 			Cookie:         "io",
 			NewId:          newId,
 			ResourceName:      "net.io",
+			ReliableTimeout: 10 * time.Second,
+			ReliableRetries: 3,
+			Codec:           TextCodec,
+			BroadcastWorkers: 32,
+			NodeId:          newNodeId(),
+			ClusterTimeout:  2 * time.Second,
+			CORS:            defaultCORS,
+			AllowJSONP:      true,
+			MountPath:       "/",
+			HandshakeTimeout: 30 * time.Second,
 		},
 		//socketChan:     make(chan Conn),
 		serverSessions: newServerSessions(),
 		creaters:       creaters,
 		transportNames: 	transports,
 		stats:          NewStatsCollector(),
-		eventEmitters : make(map[string]*EventEmitter),
+		eventEmitters : make(map[string]*Endpoint),
+		protoV1:        defaultProtocol,
+		protocols:      make(map[protocolKey]ProtocolHandler),
+		endpointIndex:  newEndpointIndex(),
+		handshaking:    syncmap.New(),
 	}
-	//go srv.garbageCollection()
+	srv.Of("")
 	return srv, nil
 }
 /*
-func (s *Server) garbageCollection() {
-	for {
-		<- time.After(10 * time.Second)
-		keys := make([]string, 0)
-		for item := range s.handshaken.IterItems() {
-			value, ok := item.Value.(*Handshake)
-			if !ok {
-				keys = append(keys, item.Key)
-				continue
-			}
-			if value.issued == false && (time.Now().Unix() - value.Time) > 30 {
-				keys = append(keys, item.Key)
-			}
-		}
-		for _, key := range keys {
-			log.Trace("garbageCollection clean ", key)
-			s.handshaken.Delete(key)
-		}
-	}
-}
-
 func (s *Server) watchMessage() {
 
 	watcher, err := s.Subscribe("dispatch-remote", func(sub, rep string, msg *store.Message) {
@@ -157,6 +178,44 @@ func (s *Server) SetPingInterval(t time.Duration) {
 	s.config.PingInterval = t
 }
 
+// SetRecoveryTimeout sets how long a session whose transport was lost is kept
+// with its buffered packets, waiting for the client to reconnect with the same
+// sid. Default is 0, which closes the session immediately.
+func (s *Server) SetRecoveryTimeout(t time.Duration) {
+	s.config.RecoveryTimeout = t
+}
+
+// SetReliableTimeout sets how long a message sent by EmitReliable may stay unacknowledged before it is retransmitted. Default is 10s.
+func (s *Server) SetReliableTimeout(t time.Duration) {
+	s.config.ReliableTimeout = t
+}
+
+// SetReliableRetries sets how many times a message sent by EmitReliable is retransmitted before its delivery fails. Default is 3.
+func (s *Server) SetReliableRetries(n int) {
+	s.config.ReliableRetries = n
+}
+
+// SetCodec sets the codec of socket.io 0.9 clients. Default is TextCodec.
+func (s *Server) SetCodec(c Codec) {
+	s.config.Codec = c
+	s.protoV1 = &v1Protocol{c}
+}
+
+// SetBroadcastWorkers sets how many goroutines write a broadcast to the connections. Default is 32.
+func (s *Server) SetBroadcastWorkers(n int) {
+	s.config.BroadcastWorkers = n
+}
+
+// SetClusterTimeout sets how long FetchSockets waits for the other nodes of the cluster. Default is 2s.
+func (s *Server) SetClusterTimeout(t time.Duration) {
+	s.config.ClusterTimeout = t
+}
+
+// SetDataStore sets the store the data bags of the connections are replicated to. Default is nil, no replication.
+func (s *Server) SetDataStore(store DataStore) {
+	s.config.DataStore = store
+}
+
 // SetMaxConnection sets the max connetion. Default is 0 ulimit.
 func (s *Server) SetMaxConnection(n int) {
 	s.config.MaxConnection = n
@@ -172,12 +231,17 @@ func (s *Server) SetAllowUpgrades(allow bool) {
 	s.config.AllowUpgrades = allow
 }
 
-// SetCookie sets the name of cookie which used by engine.io. Default is "io".
+// SetAllowJSONP sets whether server allows the jsonp-polling transport and the jsonp handshake. Default is true.
+func (s *Server) SetAllowJSONP(allow bool) {
+	s.config.AllowJSONP = allow
+}
+
+// SetCookie sets the name of cookie which used by engine.io, see SessionBinding. Default is "io".
 func (s *Server) SetCookie(prefix string) {
 	s.config.Cookie = prefix
 }
 
-// SetNewId sets the callback func to generate new connection id. By default, id is generated from remote addr + current time stamp
+// SetNewId sets the callback func to generate new connection id. By default, id is 16 random bytes from crypto/rand, base64url encoded.
 func (s *Server) SetNewId(f func(*http.Request) string) {
 	s.config.NewId = f
 }
@@ -190,6 +254,16 @@ func (s *Server) GetSessionManager() Sessions{
 	return s.serverSessions
 }
 
+// SessionCount returns the number of sessions, in O(1) when the session manager is a SessionCounter.
+func (s *Server) SessionCount() int {
+	if counter, ok := s.serverSessions.(SessionCounter); ok {
+		return counter.Len()
+	}
+	return len(s.serverSessions.IterItems())
+}
+
+// SetResourceName sets the resource of the urls, /{resource}/1/ for socket.io 0.9
+// clients, and the prefix of the keys and subjects in the stores. Default is "net.io".
 func (s *Server) SetResourceName(ns string) {
 	s.config.ResourceName = ns
 }
@@ -197,117 +271,78 @@ func (s *Server) SetResourceName(ns string) {
 func (s *Server) namespace(node string) string {
 	return fmt.Sprintf("%s.%s", s.config.ResourceName, node)
 }
-/*
-func (s *Server) onHandshake(id string, data *Handshake) {
-	s.handshaken.Set(id, data)
-}
-
-func (s *Server) handshakeData(data *IORequest) *Handshake {
 
+func (s *Server) handshakeData(sid string, data *IORequest) *Handshake {
+	r := data.Request
+	ip, scheme := s.ClientAddr(r)
+	now := time.Now()
 	return &Handshake{
 		Namespace: data.Namespace,
 		Protocol:  data.Protocol,
 		Transport: data.Transport,
-		Sid:       data.Sid,
+		Sid:       sid,
 
 		Headers: data.Headers,
-		Address: data.Request.RemoteAddr,
-		Time:    time.Now().Unix(),
-		Url:     data.Request.URL.String(),
+		Address: ip,
+		Time:    now.Unix(),
+		Query:   r.URL.Query(),
+		Url:     r.URL.String(),
 		Xdomain: data.Headers.Get("origin") != "",
-		Secure:  "",
+		Secure:  scheme == "https",
 		issued:  false,
+		at:      now,
 	}
-}*/
+}
 
-func (s *Server) handleHandshake(ir *IORequest, w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("origin") != "" {
-		// https://developer.mozilla.org/En/HTTP_Access_Control
-		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("origin"))
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
+// handleHandshake opens a session of proto, the protocol of ir.
+func (s *Server) handleHandshake(proto protocol, ir *IORequest, w http.ResponseWriter, r *http.Request) {
+	// a bad callback index is rejected before it costs a session
+	if jsonp := r.URL.Query().Get("jsonp"); jsonp != "" {
+		if _, err := polling.ParseIndex(jsonp); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
-
 	if err := s.config.AllowRequest(r); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	n := atomic.AddInt32(&s.currentConnection, 1)
-	if s.config.MaxConnection  > 0 && int(n) > s.config.MaxConnection {
-		atomic.AddInt32(&s.currentConnection, -1)
-		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+	var principal Principal
+	if s.config.Authenticator != nil {
+		var err error
+		if principal, err = s.config.Authenticator(r); err != nil {
+			s.onSecurityEvent("", SecurityAuth, r)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	sid := s.newSid(r)
+	handshake := s.handshakeData(sid, ir)
+	if reason := s.acquire(handshake.Address, principal.Id); reason != "" {
+		s.reject(ir, w, http.StatusServiceUnavailable, 4, reason)
 		return
 	}
-	
-	var err error
-	sid := s.config.NewId(r)
-	conn, err := newServerConn(sid, w, r, s)
+	conn, err := newServerConn(sid, w, r, s, proto)
 	if err != nil {
+		s.release(handshake.Address, principal.Id)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	conn.handshake = handshake
+	conn.principal = principal
+	s.bindSession(conn, w, r)
 	s.stats.SessionOpened()
 	s.serverSessions.Set(sid, conn)
-	
-
-	//handshakeData := s.handshakeData(ir)
-	//s.onHandshake(sid, handshakeData)
-
-	transports := s.transportNames
-
-	data := fmt.Sprintf("%s:%d:%d:%s",
-		sid,
-		s.config.PingInterval/time.Second,
-		s.config.PollingTimeout/time.Second,
-		strings.Join(transports, ","))
-
-	jsonp := r.URL.Query().Get("jsonp")
-	if jsonp != "" {
-		w.Header().Set("Content-Type", "application/javascript; charset=UTF-8")
-		fmt.Fprintf(w, "io.j[%s](\"%s\");", jsonp, data)
-	} else {
-		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-		fmt.Fprintf(w, "%s", data)
-	}
-}
-func checkRequest(r *http.Request) *IORequest {
-	ns := ""
-	protocol := 0
-	transport := ""
-	sid := ""
+	s.handshaking.Set(sid, conn)
+	s.startReaper()
 
-	path := r.URL.Path
-	spliter := strings.Split(path, "/")
-
-	if len(spliter) > 1 {
-		ns, _ = String(spliter[1])
-	}
-
-	if len(spliter) > 2 {
-		protocol, _ = Int(spliter[2])
-	}
-
-	if len(spliter) > 3 {
-		transport = spliter[3]
-	}
-
-	if len(spliter) > 4 {
-		sid = spliter[4]
-	}
-
-	return &IORequest{
-		Query:     r.Form,
-		Headers:   r.Header,
-		Request:   r,
-		Path:      r.URL.Path,
-		Namespace: ns,
-		Protocol:  protocol,
-		Sid:       sid,
-		Transport: transport,
-	}
+	proto.handshake(s, conn, ir, w, r)
 }
-
+// SetCrossHeader allows any origin of r, with credentials.
+//
+// Deprecated: the server applies the policy set by SetCORS.
 func SetCrossHeader(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("origin") != "" {
 		// https://developer.mozilla.org/En/HTTP_Access_Control
@@ -324,12 +359,44 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.stats.ConnectionOpened()
 	defer s.stats.ConnectionClosed()
 	
-	SetCrossHeader(w, r)
+	if !s.handleCORS(w, r) {
+		return
+	}
 
-	req := checkRequest(r)
+	req, err := s.checkRequest(r)
+	if err == ForeignPath {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	//pretty.Println("%#v", req)
+	if !s.config.AllowJSONP && (req.Transport == "jsonp-polling" || r.URL.Query().Get("jsonp") != "") {
+		http.Error(w, "jsonp not allowed", http.StatusForbidden)
+		return
+	}
+	if h := s.protocolHandler(req); h != nil {
+		h.ServeIO(req, w, r)
+		return
+	}
+	proto := s.protocolFor(req)
+	if proto == nil {
+		s.unsupportedProtocol(req, w)
+		return
+	}
 	if req.Sid == "" {
-		s.handleHandshake(req, w, r)
+		s.handleHandshake(proto, req, w, r)
+		return
+	}
+
+	node, issued, err := s.ParseId(req.Sid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if node != "" && node != s.config.NodeId && s.config.IdRouter != nil {
+		s.config.IdRouter(node, w, r)
 		return
 	}
 
@@ -339,11 +406,23 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	c := conn.(*serverConn)
+	if reason := s.checkBinding(c, r); reason != "" {
+		s.onSecurityEvent(req.Sid, reason, r)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	// 过期的 sid 不能再打开传输层, 已打开的传输层不受影响
+	if c.getCurrent() == nil && s.expiredId(issued) {
+		http.Error(w, ExpiredId.Error(), http.StatusUnauthorized)
+		return
+	}
+
 	if r.Method == "POST" {
 		s.stats.PacketsRecvPs.add(r.ContentLength)
 	}
 	
-	conn.(*serverConn).ServeHTTP(w, r)
+	c.serveRequest(req, w, r)
 }
 
 // Accept returns Conn when client connect to server.
@@ -351,6 +430,20 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	return <-s.socketChan, nil
 }*/
 
+// advertisedTransports returns the transports offered in the handshake.
+func (s *Server) advertisedTransports() []string {
+	if s.config.AllowJSONP {
+		return s.transportNames
+	}
+	ret := make([]string, 0, len(s.transportNames))
+	for _, name := range s.transportNames {
+		if name != "jsonp-polling" {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
 func (s *Server) configure() config {
 	return s.config
 }
@@ -378,36 +471,38 @@ func (s *Server) SubscribeAsync(subj string) (store.Subscription, error){
 }*/
 
 func (s *Server) onClose(id string) {
-	s.serverSessions.Remove(id)
-	if s.config.MaxConnection  > 0 {
-		atomic.AddInt32(&s.currentConnection, -1)
+	conn, _ := s.serverSessions.Get(id).(*serverConn)
+	if conn == nil || !atomic.CompareAndSwapInt32(&conn.released, 0, 1) {
+		// 已经关闭过
+		return
 	}
+	s.serverSessions.Remove(id)
+	s.handshaking.Delete(id)
+	s.release(conn.handshake.Address, conn.principal.Id)
 	s.stats.SessionClosed()
 }
 
-func (srv *Server) Of(name string) *EventEmitter {
+// Of returns the namespace name, defining it. Clients can only connect to the
+// namespaces defined.
+func (srv *Server) Of(name string) *Endpoint {
+	srv.emittersLocker.Lock()
+	defer srv.emittersLocker.Unlock()
+
 	ret, ok := srv.eventEmitters[name]
 	if !ok {
-		ret = NewEventEmitter()
+		ret = newEndpoint(srv, name)
 		srv.eventEmitters[name] = ret
 	}
 	return ret
 }
 
 func (srv *Server) In(name string) *Broadcaster {
-	namespaces := []*NameSpace{}
-	for _, conn := range srv.serverSessions.IterItems() {
-		ns := conn.Of(name)
-		if ns != nil {
-			namespaces = append(namespaces, ns)
-		}
-	}
-
-	return &Broadcaster{Namespaces: namespaces}
+	namespaces := srv.endpointIndex.namespaces(name)
+	return &Broadcaster{Namespaces: namespaces, Workers: srv.config.BroadcastWorkers}
 }
 
-func (srv *Server) Broadcast(name string, args ...interface{}) {
-	srv.In("").Broadcast(name, args...)
+func (srv *Server) Broadcast(name string, args ...interface{}) BroadcastResult {
+	return srv.In("").Broadcast(name, args...)
 }
 
 func (srv *Server) Except(ns *NameSpace) *Broadcaster {
@@ -426,18 +521,18 @@ func (srv *Server) RemoveAllListeners(name string) {
 	srv.Of("").RemoveAllListeners(name)
 }
 
-func (srv *Server) getEmitter(name string) *EventEmitter {
-	ee := srv.eventEmitters[name]
-	if ee == nil {
-		srv.eventEmitters[name] = NewEventEmitter()
-		ee = srv.eventEmitters[name]
-	}
-	return ee
+func (srv *Server) endpoints() *endpointIndex {
+	return srv.endpointIndex
 }
 
+// getEmitter returns the emitter of the namespace name, nil if not defined.
+func (srv *Server) getEmitter(name string) *EventEmitter {
+	srv.emittersLocker.RLock()
+	defer srv.emittersLocker.RUnlock()
 
-func newId(r *http.Request) string {
-	hash := fmt.Sprintf("%d %s %s", rand.Uint32(), r.RemoteAddr, time.Now())
-	md5sid := md5.Sum([]byte(hash))
-	return fmt.Sprintf("%x", md5sid)
+	if ret := srv.eventEmitters[name]; ret != nil {
+		return ret.EventEmitter
+	}
+	return nil
 }
+
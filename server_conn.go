@@ -27,11 +27,22 @@ type Conn interface {
 
 	Of(name string) *NameSpace
 
+	// Handshake returns the data of the handshake request.
+	Handshake() *Handshake
+
+	// Data returns the user data of the connection.
+	Data() *DataBag
+
+	// Principal returns the client authenticated by the handshake, see
+	// SetAuthenticator.
+	Principal() Principal
+
 	io.Writer
 }
 
 var NotConnected = errors.New("not connected")
 var ClosedError = errors.New("closed")
+var DeliveryError = errors.New("delivery failed")
 
 type transportCreaters map[string]transport.Creater
 
@@ -52,6 +63,9 @@ type serverCallback interface {
 	transports() transportCreaters
 	onClose(sid string)
 	getEmitter(name string) *EventEmitter
+	endpoints() *endpointIndex
+	acquireNamespace(endpoint string) bool
+	releaseNamespace(endpoint string)
 
 	Stats() *StatsCollector
 }
@@ -62,6 +76,7 @@ const (
 	stateUnknow state = iota
 	stateNormal
 	stateUpgrading
+	stateDisconnected
 	stateClosing
 	stateClosed
 )
@@ -75,12 +90,17 @@ type serverConn struct {
 	current         transport.Server
 	upgradingName   string
 	upgrading       transport.Server
+	probe           *upgradeCallback
+	draining        transport.Server
+	drained         chan struct{}
+	recovery        *time.Timer
 	state           state
 	stateLocker     sync.RWMutex
 	writeLocker     sync.RWMutex
 	closeOnce		sync.Once
-	in              chan []byte
+	in              chan outgoing
 	senderChan      chan []byte
+	messageChan     chan transport.Message
 
 	pingInterval     time.Duration
 	pingTimeout		 time.Duration
@@ -88,13 +108,20 @@ type serverConn struct {
 	missedHeartbeats int32
 	
 
+	proto      protocol
+	handshake  *Handshake
+	fingerprint *fingerprint
+	principal  Principal
+	released   int32
+	data       *DataBag
 	nameSpaces map[string]*NameSpace
+	nameSpacesLocker sync.RWMutex
 	defaultNS  *NameSpace
 }
 
 var InvalidError = errors.New("invalid transport")
 
-func newServerConn(id string, w http.ResponseWriter, r *http.Request, callback serverCallback) (*serverConn, error) {
+func newServerConn(id string, w http.ResponseWriter, r *http.Request, callback serverCallback, proto protocol) (*serverConn, error) {
 	/*transportName := req.Transport
 	creater := callback.transports().Get(transportName)
 	if creater.Name == "" {
@@ -106,10 +133,14 @@ func newServerConn(id string, w http.ResponseWriter, r *http.Request, callback s
 		request:      r,
 		callback:     callback,
 		state:        stateNormal,
-		in:           make(chan []byte),
+		in:           make(chan outgoing),
 		senderChan:   make(chan []byte, 0),
+		messageChan:  make(chan transport.Message),
 		pingInterval: callback.configure().PingInterval,
 		pingTimeout: callback.configure().PingTimeout,
+		proto:        proto,
+		handshake:    &Handshake{Sid: id},
+		data:         newDataBag(callback.configure().DataStore, fmt.Sprintf("%s.data.%s.", callback.configure().ResourceName, id)),
 		nameSpaces:   make(map[string]*NameSpace),
 	}
 
@@ -120,9 +151,8 @@ func newServerConn(id string, w http.ResponseWriter, r *http.Request, callback s
 	ret.setCurrent(transportName, transport) */
 
 	ret.ping = ret.pingLoop()
-	go ret.infinityQueue(ret.in, ret.senderChan)
-	ret.defaultNS = ret.Of("")
-	ret.onOpen()
+	go ret.infinityQueue(ret.in, ret.senderChan, ret.messageChan)
+	ret.defaultNS = ret.open("")
 
 	return ret, nil
 }
@@ -135,21 +165,48 @@ func (c *serverConn) Request() *http.Request {
 	return c.request
 }
 
+func (c *serverConn) Handshake() *Handshake {
+	return c.handshake
+}
+
+func (c *serverConn) Principal() Principal {
+	return c.principal
+}
+
+// CheckOrigin checks the origin of the websocket upgrades, see CORSOptions.
+func (c *serverConn) CheckOrigin(r *http.Request) bool {
+	return c.callback.configure().CORS.checkOrigin(r)
+}
+
+func (c *serverConn) Data() *DataBag {
+	return c.data
+}
+
+// Set sets key of the user data, see DataBag.
+func (c *serverConn) Set(key string, val interface{}) {
+	c.data.Set(key, val)
+}
+
+// Get returns key of the user data, see DataBag.
+func (c *serverConn) Get(key string) interface{} {
+	return c.data.Get(key)
+}
+
 func (c *serverConn) Close() error {
 	
 	c.closeOnce.Do(func(){
-		if c.getState() != stateNormal && c.getState() != stateUpgrading {
+		if s := c.getState(); s != stateNormal && s != stateUpgrading && s != stateDisconnected {
 			return 
 		}
-		if c.upgrading != nil {
-			c.upgrading.Close()
-		}
+		c.stopRecovery()
+		c.abortUpgrade(c.getUpgrade())
 		
 		c.setState(stateClosing)
-		for _, ns := range c.nameSpaces {
+		for _, ns := range c.getNameSpaces() {
 			ns.onDisconnect()
 		}
 		c.defaultNS.emit("close", c.defaultNS, nil)
+		c.data.clear()
 	
 		close(c.ping)
 		c.CloseWriter()
@@ -160,69 +217,142 @@ func (c *serverConn) Close() error {
 
 func (c *serverConn) OnClose(server transport.Server) {
 	log.Debugf("[%s] OnClose", c.Id())
-	//TODO c.Close() 因为websocket 网络异常就直接调用onClose了，不像polling那是是被动的
-	//所以这里先临时执行一下c.Close以清除相关事件
-	c.Close()
 	if server != nil {
-		if t := c.getUpgrade(); server == t {
-			c.setUpgrading("", nil)
-			t.Close()
+		if c.abortUpgrade(server) || c.finishDrain(server) {
 			return
 		}
-		
-		t := c.getCurrent()
-		if server != t {
+		if server != c.getCurrent() {
 			return
 		}
-		t.Close()
-		
-		if t := c.getUpgrade(); t != nil {
-			t.Close()
-			c.setUpgrading("", nil)
+		// websocket 网络异常会直接调用OnClose，允许恢复时先挂起会话等待客户端重连
+		if c.suspend(server) {
+			return
 		}
 	}
-	
+
+	c.Close()
+	if server != nil {
+		server.Close()
+		c.abortUpgrade(c.getUpgrade())
+	}
+
 	c.setState(stateClosed)
 	c.callback.onClose(c.id)
 }
 
+// suspend detaches the lost transport and keeps the session, with everything
+// still queued for the client, for RecoveryTimeout.
+func (c *serverConn) suspend(server transport.Server) bool {
+	timeout := c.callback.configure().RecoveryTimeout
+	if timeout <= 0 || c.getState() != stateNormal {
+		return false
+	}
+	log.Debugf("[%s] transport lost, waiting %s for reconnect", c.Id(), timeout)
+
+	c.transportLocker.Lock()
+	c.current = nil
+	c.currentName = ""
+	c.recovery = time.AfterFunc(timeout, c.expire)
+	c.transportLocker.Unlock()
+
+	c.setState(stateDisconnected)
+	server.Close()
+	return true
+}
+
+// resume reattaches a suspended session to the transport the client
+// reconnected with. Queued packets are flushed to it in order.
+func (c *serverConn) resume() {
+	c.stopRecovery()
+	atomic.StoreInt32(&c.missedHeartbeats, 0)
+	c.setState(stateNormal)
+	log.Debugf("[%s] resumed on %s", c.Id(), c.getCurrentName())
 
-func (c *serverConn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	req := checkRequest(r)
+	c.retransmit(true)
+	for _, ns := range c.getNameSpaces() {
+		if ns.isConnected() {
+			ns.emit("reconnect", ns, nil)
+		}
+	}
+}
+
+// retransmit resends unacknowledged reliable messages of every namespace.
+// Unless force is set only messages older than ReliableTimeout are resent.
+func (c *serverConn) retransmit(force bool) {
+	config := c.callback.configure()
+	for _, ns := range c.getNameSpaces() {
+		ns.retransmit(force, config.ReliableTimeout, config.ReliableRetries)
+	}
+}
+
+func (c *serverConn) expire() {
+	if c.getState() != stateDisconnected {
+		return
+	}
+	log.Debugf("[%s] recovery timeout", c.Id())
+	c.Close()
+}
+
+func (c *serverConn) stopRecovery() {
+	c.transportLocker.Lock()
+	defer c.transportLocker.Unlock()
+
+	if c.recovery != nil {
+		c.recovery.Stop()
+		c.recovery = nil
+	}
+}
+
+
+func (c *serverConn) serveRequest(req *IORequest, w http.ResponseWriter, r *http.Request) {
 	transportName := req.Transport
 	
 	if c.getCurrent() == nil {
 		creater := c.callback.transports().Get(transportName)
+		if creater.Server == nil {
+			http.Error(w, fmt.Sprintf("invalid transport %s", transportName), http.StatusBadRequest)
+			return
+		}
 		transport, err := creater.Server(w, r, c)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("invalid transport %s", transportName), http.StatusBadRequest)
 			return
 		}
 		c.setCurrent(transportName, transport)
+		if c.getState() == stateDisconnected {
+			c.resume()
+		}
 	}
 	
-	if c.currentName != transportName {
-		creater := c.callback.transports().Get(transportName)
-		if creater.Name == "" {
-			http.Error(w, fmt.Sprintf("invalid transport %s", transportName), http.StatusBadRequest)
-			return
-		}
-		u, err := creater.Server(w, r, c)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		c.setUpgrading(creater.Name, u)
-		c.upgraded()
+	if name, u := c.getUpgrading(); u != nil && transportName == name {
+		u.ServeHTTP(w, r)
+		return
 	}
 
-	c.current.ServeHTTP(w, r)
+	name, current := c.getCurrentTransport()
+	if name != transportName {
+		c.upgrade(transportName, w, r)
+		return
+	}
+
+	current.ServeHTTP(w, r)
+}
+func (c *serverConn) PollingTimeout() time.Duration {
+	return c.callback.configure().PollingTimeout
+}
+
+func (c *serverConn) Noop() []byte {
+	payload, _ := c.proto.encodePayload(c.getCurrentName(), [][]byte{c.proto.encodePacket("", Noop())})
+	return payload
 }
+
 func (c *serverConn) OnRawDispatchRemote(data []byte) {
 	log.Tracef("[%s]<<< %s", c.Id(), string(data))
 
 	sl := len(data)
 	c.callback.Stats().PacketsSentPs.add(int64(sl))
+
+	c.retransmit(false)
 }
 func (c *serverConn) OnRawMessage(data []byte) {
 	log.Tracef("[%s]>>> %s", c.Id(), string(data))
@@ -230,7 +360,7 @@ func (c *serverConn) OnRawMessage(data []byte) {
 	sl := len(data)
 	c.callback.Stats().PacketsRecvPs.add(int64(sl))
 
-	packets, err := decodePayload(data)
+	packets, err := c.proto.decodePayload(data)
 	if err != nil {
 		log.Errorf("[%s] decodePayload error [%s] [%s]", c.id, err, string(data))
 	}
@@ -258,7 +388,24 @@ func (c *serverConn) OnRawPacket(packet Packet) error {
 	}
 
 	ns := c.Of(packet.EndPoint())
+	if _, ok := packet.(*connectPacket); ok && !c.admitNamespace(ns, packet.EndPoint()) {
+		return nil
+	}
+	if ns == nil {
+		switch packet.(type) {
+		case *connectPacket:
+			ns = c.open(packet.EndPoint())
+		case *disconnectPacket:
+			return nil
+		}
+	}
 	if ns == nil {
+		if endpoint := packet.EndPoint(); c.callback.getEmitter(endpoint) == nil {
+			log.Warnf("[%s] invalid namespace %s", c.Id(), endpoint)
+			reply := new(errorPacket)
+			reply.reason = "invalid namespace"
+			c.writePacket(endpoint, reply)
+		}
 		return nil
 	}
 	ns.onPacket(packet)
@@ -272,6 +419,9 @@ func (c *serverConn) OnPacket(packet Packet) error {
 	switch packet.(type) {
 	case *heartbeatPacket:
 		atomic.StoreInt32(&c.missedHeartbeats, 0)
+		if !c.proto.serverPings() {
+			c.writePacket("", new(heartbeatPacket))
+		}
 	case *disconnectPacket:
 		{
 			if packet.EndPoint() == "" {
@@ -291,15 +441,26 @@ func (c *serverConn) OnPacket(packet Packet) error {
 }
 
 func (s *serverConn) onOpen() error {
+	if !s.proto.autoConnect() {
+		return nil
+	}
 
 	packet := new(connectPacket)
-	s.defaultNS.connected = true
+	packet.sid = s.id
+	s.defaultNS.setConnected(true)
 	err := s.defaultNS.sendPacket(packet)
 	s.defaultNS.emit("connect", s.defaultNS, nil)
 
 	return err
 }
 
+// writePacket writes a packet of the connection itself, regardless of the
+// namespace being connected.
+func (c *serverConn) writePacket(endpoint string, packet Packet) error {
+	_, err := c.Write(c.proto.encodePacket(endpoint, packet))
+	return err
+}
+
 /*func (s *serverConn) namespace(name string) string {
 		return fmt.Sprintf("conn.%s-%s", name, s.Id())
 }
@@ -314,6 +475,10 @@ func (s *serverConn) SenderChan() chan []byte {
 	return s.senderChan
 }
 
+func (s *serverConn) MessageChan() chan transport.Message {
+	return s.messageChan
+}
+
 func (c *serverConn) getCurrent() transport.Server {
 	c.transportLocker.RLock()
 	defer c.transportLocker.RUnlock()
@@ -321,43 +486,33 @@ func (c *serverConn) getCurrent() transport.Server {
 	return c.current
 }
 
-func (c *serverConn) getUpgrade() transport.Server {
+func (c *serverConn) getCurrentName() string {
 	c.transportLocker.RLock()
 	defer c.transportLocker.RUnlock()
 
-	return c.upgrading
+	return c.currentName
 }
 
-func (c *serverConn) setCurrent(name string, s transport.Server) {
-	c.transportLocker.Lock()
-	defer c.transportLocker.Unlock()
+func (c *serverConn) getCurrentTransport() (string, transport.Server) {
+	c.transportLocker.RLock()
+	defer c.transportLocker.RUnlock()
 
-	c.currentName = name
-	c.current = s
+	return c.currentName, c.current
 }
 
-func (c *serverConn) setUpgrading(name string, s transport.Server) {
-	c.transportLocker.Lock()
-	defer c.transportLocker.Unlock()
+func (c *serverConn) getUpgrade() transport.Server {
+	c.transportLocker.RLock()
+	defer c.transportLocker.RUnlock()
 
-	c.upgradingName = name
-	c.upgrading = s
-	c.setState(stateUpgrading)
+	return c.upgrading
 }
 
-func (c *serverConn) upgraded() {
+func (c *serverConn) setCurrent(name string, s transport.Server) {
 	c.transportLocker.Lock()
+	defer c.transportLocker.Unlock()
 
-	current := c.current
-	c.current = c.upgrading
-	c.currentName = c.upgradingName
-	c.upgrading = nil
-	c.upgradingName = ""
-
-	c.transportLocker.Unlock()
-
-	current.Close()
-	c.setState(stateNormal)
+	c.currentName = name
+	c.current = s
 }
 
 func (c *serverConn) getState() state {
@@ -372,13 +527,66 @@ func (c *serverConn) setState(state state) {
 	c.state = state
 }
 
-func (c *serverConn) Of(name string) (nameSpace *NameSpace) {
-	if nameSpace = c.nameSpaces[name]; nameSpace == nil {
-		ee := c.callback.getEmitter(name)
-		nameSpace = NewNameSpace(c, name, ee)
-		c.nameSpaces[name] = nameSpace
+// Of returns the namespace name of the connection, nil if the client did not
+// connect to it.
+func (c *serverConn) Of(name string) *NameSpace {
+	c.nameSpacesLocker.RLock()
+	defer c.nameSpacesLocker.RUnlock()
+
+	return c.nameSpaces[name]
+}
+
+// admitNamespace counts a connection to endpoint, unless it is over the limit.
+// The connection is uncounted when ns is disconnected.
+func (c *serverConn) admitNamespace(ns *NameSpace, endpoint string) bool {
+	if endpoint == "" || (ns != nil && ns.isConnected()) || c.callback.getEmitter(endpoint) == nil {
+		return true
+	}
+	if c.callback.acquireNamespace(endpoint) {
+		return true
+	}
+	log.Warnf("[%s] %s %s", c.Id(), tooManyNamespace, endpoint)
+	reply := new(errorPacket)
+	reply.reason = tooManyNamespace
+	c.writePacket(endpoint, reply)
+	return false
+}
+
+// open returns the namespace name, creating it if the endpoint is defined by
+// Server.Of. It returns nil for undefined endpoints.
+func (c *serverConn) open(name string) *NameSpace {
+	c.nameSpacesLocker.Lock()
+	defer c.nameSpacesLocker.Unlock()
+
+	if nameSpace := c.nameSpaces[name]; nameSpace != nil {
+		return nameSpace
+	}
+	ee := c.callback.getEmitter(name)
+	if ee == nil {
+		return nil
+	}
+	nameSpace := NewNameSpace(c, name, ee)
+	nameSpace.proto = c.proto
+	nameSpace.index = c.callback.endpoints()
+	if name != "" {
+		// uncounts the connection admitNamespace counted
+		nameSpace.onLeave = func() {
+			c.callback.releaseNamespace(name)
+		}
+	}
+	c.nameSpaces[name] = nameSpace
+	return nameSpace
+}
+
+func (c *serverConn) getNameSpaces() []*NameSpace {
+	c.nameSpacesLocker.RLock()
+	defer c.nameSpacesLocker.RUnlock()
+
+	ret := make([]*NameSpace, 0, len(c.nameSpaces))
+	for _, ns := range c.nameSpaces {
+		ret = append(ret, ns)
 	}
-	return
+	return ret
 }
 
 func (c *serverConn) CloseWriter() error {
@@ -388,7 +596,15 @@ func (c *serverConn) CloseWriter() error {
 	return nil
 }
 
-func (c *serverConn) Write(p []byte) (n int, err error) {
+func (c *serverConn) Write(p []byte) (int, error) {
+	if err := c.writeMessage(outgoing{data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeMessage queues msg for the client.
+func (c *serverConn) writeMessage(msg outgoing) (err error) {
 	c.writeLocker.Lock()
 	defer c.writeLocker.Unlock()
 	
@@ -400,17 +616,16 @@ func (c *serverConn) Write(p []byte) (n int, err error) {
 	
 	for {
 		if c.getState() == stateClosed || c.getState() == stateClosing {
-			return 0, ClosedError
+			return ClosedError
 		}
 		
 		select {
-		case c.in <- p :
-			return len(p), nil
+		case c.in <- msg :
+			return nil
 		case <- time.After(1 * time.Second) : {}
 		}
 		
 	}
-	return 0, ClosedError
 }
 
 func (c *serverConn) pingLoop() chan bool {
@@ -421,7 +636,12 @@ func (c *serverConn) pingLoop() chan bool {
 			select {
 			case <-ticker.C:
 				{
-					c.defaultNS.sendPacket(new(heartbeatPacket))
+					if c.getState() == stateDisconnected {
+						continue
+					}
+					if c.proto.serverPings() {
+						c.writePacket("", new(heartbeatPacket))
+					}
 					n := atomic.AddInt32(&c.missedHeartbeats, 1)
 	
 					// TODO: Configurable
@@ -443,11 +663,47 @@ func (c *serverConn) pingLoop() chan bool {
 	
 }
 
-func (c *serverConn) infinityQueue(in <-chan []byte, next chan<- []byte) {
+// outgoing is a packet queued for the client, with its options.
+type outgoing struct {
+	data     []byte
+	compress bool
+	// written is called once the packet is handed to a transport.
+	written func()
+}
+
+// handed calls the written callbacks of the packets handed to a transport.
+func handed(packets []outgoing) {
+	for _, msg := range packets {
+		if msg.written != nil {
+			msg.written()
+		}
+	}
+}
+
+// encodePending frames the first packets of pending for one write, see
+// protocol.encodePayload. The payload is compressed if any of its packets asks
+// for it.
+func (c *serverConn) encodePending(pending []outgoing) (transport.Message, int) {
+	packets := make([][]byte, len(pending))
+	for i, msg := range pending {
+		packets[i] = msg.data
+	}
+	payload, n := c.proto.encodePayload(c.getCurrentName(), packets)
+	ret := transport.Message{Data: payload}
+	for _, msg := range pending[:n] {
+		ret.Compress = ret.Compress || msg.compress
+	}
+	return ret, n
+}
+
+// infinityQueue hands the queued packets to the transport, on next or on
+// messages for the transports reading MessageChan.
+func (c *serverConn) infinityQueue(in <-chan outgoing, next chan<- []byte, messages chan<- transport.Message) {
 	defer close(next)
+	defer close(messages)
 
 	// pending events (this is the "infinite" part)
-	pending := make([][]byte, 0)
+	pending := make([]outgoing, 0)
 
 recv:
 	for {
@@ -462,6 +718,7 @@ recv:
 			// We now have something to send
 			pending = append(pending, v)
 		}
+		payload, n := c.encodePending(pending)
 
 		select {
 		// Queue incoming values
@@ -474,8 +731,12 @@ recv:
 			pending = append(pending, v)
 
 		// Send queued values
-		case next <- encodePayload(pending):
-			pending = pending[:0]
+		case next <- payload.Data:
+			handed(pending[:n])
+			pending = pending[n:]
+		case messages <- payload:
+			handed(pending[:n])
+			pending = pending[n:]
 		}
 	}
 	if c.getCurrent() == nil {
@@ -483,12 +744,21 @@ recv:
 		c.OnClose(nil)
 		return 
 	}
-	if len(pending) > 0 {
+	timeout := time.After(c.pingTimeout)
+flush:
+	for len(pending) > 0 {
+		payload, n := c.encodePending(pending)
 		select {
-		case next <- encodePayload(pending):
-			pending = pending[:0]
+		case next <- payload.Data:
+			handed(pending[:n])
+			pending = pending[n:]
+			log.Debugf("[%s] Sending the last data and close transport", c.Id())
+		case messages <- payload:
+			handed(pending[:n])
+			pending = pending[n:]
 			log.Debugf("[%s] Sending the last data and close transport", c.Id())
-		case <-time.After(c.pingTimeout):
+		case <-timeout:
+			break flush
 		}
 	}
 	transport := c.getCurrent()
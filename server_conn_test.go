@@ -0,0 +1,101 @@
+package netio
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestRecovery(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.SetRecoveryTimeout(time.Minute)
+	reconnected := make(chan *NameSpace, 1)
+	srv.On("reconnect", func(ns *NameSpace) {
+		reconnected <- ns
+	})
+	sid := client.handshake()
+	conn := srv.GetSessionManager().Get(sid).(*serverConn)
+	defer conn.Close()
+	assert.Equal(t, true, strings.HasPrefix(client.get("/socket.io/1/xhr-polling/"+sid).Body.String(), "1::"))
+
+	// 传输层断开, 会话挂起
+	conn.getCurrent().Close()
+	assert.Equal(t, stateDisconnected, conn.getState())
+	assert.Equal(t, 1, srv.SessionCount())
+	conn.Of("").Emit("news", "queued")
+
+	w := client.get("/socket.io/1/unknown/" + sid)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, stateDisconnected, conn.getState())
+
+	w = client.get("/socket.io/1/xhr-polling/" + sid)
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `"args":["queued"]`))
+	assert.Equal(t, stateNormal, conn.getState())
+	select {
+	case ns := <-reconnected:
+		assert.Equal(t, sid, ns.Id())
+	case <-time.After(time.Second):
+		t.Error("no reconnect event")
+	}
+}
+
+func TestRecoveryExpire(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.SetRecoveryTimeout(20 * time.Millisecond)
+	sid := client.handshake()
+	conn := srv.GetSessionManager().Get(sid).(*serverConn)
+	client.get("/socket.io/1/xhr-polling/" + sid)
+	conn.getCurrent().Close()
+	assert.Equal(t, stateDisconnected, conn.getState())
+
+	for i := 0; i < 100 && srv.SessionCount() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, srv.SessionCount())
+	w := client.get("/socket.io/1/xhr-polling/" + sid)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestNameSpaces(t *testing.T) {
+	srv, client := newTestServer(t)
+	connected := make(chan *NameSpace, 1)
+	srv.Of("/chat").On("connect", func(ns *NameSpace) {
+		connected <- ns
+	})
+	sid := client.handshake()
+	conn := srv.GetSessionManager().Get(sid).(*serverConn)
+	defer conn.Close()
+	client.get("/socket.io/1/xhr-polling/" + sid)
+	assert.Equal(t, (*NameSpace)(nil), conn.Of("/chat"))
+
+	client.post("/socket.io/1/xhr-polling/"+sid, "1::/chat")
+	select {
+	case ns := <-connected:
+		assert.Equal(t, "/chat", ns.Endpoint())
+		assert.Equal(t, ns, conn.Of("/chat"))
+	case <-time.After(time.Second):
+		t.Error("no connect event")
+	}
+
+	// 未定义的 namespace 回复错误, 不创建
+	client.post("/socket.io/1/xhr-polling/"+sid, "1::/unknown")
+	client.post("/socket.io/1/xhr-polling/"+sid, "0::/unknown")
+	assert.Equal(t, (*NameSpace)(nil), conn.Of("/unknown"))
+	w := client.get("/socket.io/1/xhr-polling/" + sid)
+	assert.Equal(t, true, strings.HasSuffix(w.Body.String(), "\ufffd7::/unknown:invalid namespace"))
+	// socket.io 0.9 的连接不回复 connect 包
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `5::/chat:{"name":"connect"`))
+	assert.Equal(t, false, strings.Contains(w.Body.String(), "1::/chat"))
+}
+
+func TestPollingNoop(t *testing.T) {
+	srv, client := newTestServer(t)
+	sid := client.handshake()
+	conn := srv.GetSessionManager().Get(sid).(*serverConn)
+	defer conn.Close()
+	assert.Equal(t, "8::", string(conn.Noop()))
+	assert.Equal(t, 20*time.Second, conn.PollingTimeout())
+}
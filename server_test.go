@@ -0,0 +1,44 @@
+package netio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestJSONP(t *testing.T) {
+	srv, client := newTestServer(t)
+	handshake := func(query string) *httptest.ResponseRecorder {
+		return client.get("/socket.io/1/?" + query)
+	}
+	authenticated := 0
+	srv.SetAuthenticator(func(r *http.Request) (Principal, error) {
+		authenticated++
+		return Principal{}, nil
+	})
+
+	// 错误的 index 不创建会话, 也不认证
+	w := handshake("jsonp=" + url.QueryEscape("0](alert(1));io.j[0"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, false, strings.Contains(w.Body.String(), "alert"))
+	assert.Equal(t, 0, srv.SessionCount())
+	assert.Equal(t, 0, authenticated)
+
+	w = handshake("jsonp=0")
+	assert.Equal(t, true, strings.HasPrefix(w.Body.String(), `io.j[0]("`))
+	sid := strings.Split(strings.TrimPrefix(w.Body.String(), `io.j[0]("`), ":")[0]
+	srv.GetSessionManager().Get(sid).Close()
+
+	srv.SetAllowJSONP(false)
+	w = handshake("jsonp=0")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = handshake("")
+	assert.Equal(t, false, strings.Contains(w.Body.String(), "jsonp-polling"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	sid = strings.Split(w.Body.String(), ":")[0]
+	srv.GetSessionManager().Get(sid).Close()
+}
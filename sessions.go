@@ -2,51 +2,132 @@ package netio
 
 import (
 	"sync"
+	"sync/atomic"
+
+	"github.com/xjtdy888/netio/syncmap"
 )
 
 type Sessions interface {
 	Get(id string) Conn
 	Set(id string, conn Conn)
 	Remove(id string)
+	// IterItems returns a snapshot of the sessions.
 	IterItems() map[string]Conn
 }
 
+// SessionCounter is implemented by the Sessions that count their sessions
+// without taking a snapshot.
+type SessionCounter interface {
+	Len() int
+}
+
 type serverSessions struct {
-	sessions map[string]Conn
-	locker   sync.RWMutex
+	sessions *syncmap.SyncMap
+	count    int32
 }
 
 func newServerSessions() *serverSessions {
 	return &serverSessions{
-		sessions: make(map[string]Conn),
+		sessions: syncmap.New(),
 	}
 }
 
 func (s *serverSessions) Get(id string) Conn {
-	s.locker.RLock()
-	defer s.locker.RUnlock()
-
-	ret, ok := s.sessions[id]
+	ret, ok := s.sessions.Get(id)
 	if !ok {
 		return nil
 	}
-	return ret
+	return ret.(Conn)
 }
 
 func (s *serverSessions) Set(id string, conn Conn) {
-	s.locker.Lock()
-	defer s.locker.Unlock()
-
-	s.sessions[id] = conn
+	if _, loaded := s.sessions.Swap(id, conn); !loaded {
+		atomic.AddInt32(&s.count, 1)
+	}
 }
 
 func (s *serverSessions) Remove(id string) {
-	s.locker.Lock()
-	defer s.locker.Unlock()
-
-	delete(s.sessions, id)
+	if _, ok := s.sessions.Take(id); ok {
+		atomic.AddInt32(&s.count, -1)
+	}
 }
 
 func (s *serverSessions) IterItems() map[string]Conn {
-	return s.sessions
+	ret := make(map[string]Conn, s.Len())
+	for item := range s.sessions.IterItems() {
+		ret[item.Key] = item.Value.(Conn)
+	}
+	return ret
+}
+
+func (s *serverSessions) Len() int {
+	return int(atomic.LoadInt32(&s.count))
+}
+
+// endpointIndex indexes the connected namespaces by endpoint, so Server.In
+// does not scan every session.
+type endpointIndex struct {
+	locker    sync.RWMutex
+	endpoints map[string]*syncmap.SyncMap
+}
+
+func newEndpointIndex() *endpointIndex {
+	return &endpointIndex{
+		endpoints: make(map[string]*syncmap.SyncMap),
+	}
+}
+
+func (x *endpointIndex) get(key string) *syncmap.SyncMap {
+	x.locker.RLock()
+	defer x.locker.RUnlock()
+
+	return x.endpoints[key]
+}
+
+// add indexes ns under key, its endpoint or one of its rooms, see roomKey.
+// Sets are written under the read lock, so remove never deletes a set while a
+// namespace is added to it.
+func (x *endpointIndex) add(key string, ns *NameSpace) {
+	x.locker.RLock()
+	if m := x.endpoints[key]; m != nil {
+		m.Set(ns.Id(), ns)
+		x.locker.RUnlock()
+		return
+	}
+	x.locker.RUnlock()
+
+	x.locker.Lock()
+	defer x.locker.Unlock()
+	m := x.endpoints[key]
+	if m == nil {
+		m = syncmap.New()
+		x.endpoints[key] = m
+	}
+	m.Set(ns.Id(), ns)
+}
+
+// remove removes ns from key, and key from the index once it is empty.
+func (x *endpointIndex) remove(key string, ns *NameSpace) {
+	x.locker.Lock()
+	defer x.locker.Unlock()
+
+	if m := x.endpoints[key]; m != nil {
+		m.Delete(ns.Id())
+		if m.Size() == 0 {
+			delete(x.endpoints, key)
+		}
+	}
+}
+
+// namespaces returns the namespaces indexed under key.
+func (x *endpointIndex) namespaces(key string) []*NameSpace {
+	m := x.get(key)
+	if m == nil {
+		return []*NameSpace{}
+	}
+	ret := make([]*NameSpace, 0, m.Size())
+	for item := range m.IterItems() {
+		ret = append(ret, item.Value.(*NameSpace))
+	}
+	return ret
 }
@@ -0,0 +1,52 @@
+package netio
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSessions(t *testing.T) {
+	sessions := newServerSessions()
+	sessions.Set("a", &broadcastConn{id: "a"})
+	sessions.Set("a", &broadcastConn{id: "a"})
+	sessions.Set("b", &broadcastConn{id: "b"})
+	assert.Equal(t, 2, sessions.Len())
+
+	items := sessions.IterItems()
+	sessions.Remove("a")
+	sessions.Remove("a")
+	assert.Equal(t, 1, sessions.Len())
+	assert.Equal(t, 2, len(items))
+	assert.Equal(t, nil, sessions.Get("a"))
+
+	index := newEndpointIndex()
+	ns := NewNameSpace(sessions.Get("b"), "/chat", NewEventEmitter())
+	ns.index = index
+	ns.setConnected(true)
+	assert.Equal(t, []*NameSpace{ns}, index.namespaces("/chat"))
+	ns.setConnected(false)
+	assert.Equal(t, 0, len(index.namespaces("/chat")))
+}
+
+// mapSessions is a Sessions without Len.
+type mapSessions map[string]Conn
+
+func (m mapSessions) Get(id string) Conn         { return m[id] }
+func (m mapSessions) Set(id string, conn Conn)   { m[id] = conn }
+func (m mapSessions) Remove(id string)           { delete(m, id) }
+func (m mapSessions) IterItems() map[string]Conn { return m }
+
+func TestSessionCount(t *testing.T) {
+	srv, err := NewServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.GetSessionManager().Set("a", &broadcastConn{id: "a"})
+	assert.Equal(t, 1, srv.SessionCount())
+
+	srv.SetSessionManager(mapSessions{})
+	srv.GetSessionManager().Set("a", &broadcastConn{id: "a"})
+	srv.GetSessionManager().Set("b", &broadcastConn{id: "b"})
+	assert.Equal(t, 2, srv.SessionCount())
+}
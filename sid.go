@@ -0,0 +1,132 @@
+package netio
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	InvalidId = errors.New("invalid sid")
+	ExpiredId = errors.New("expired sid")
+)
+
+const (
+	sidBytes     = 16
+	sidMaxLength = 128
+	sidMacBytes  = 16
+)
+
+// newId returns 16 random bytes from crypto/rand, base64url encoded.
+func newId(r *http.Request) string {
+	b := make([]byte, sidBytes)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// newNodeId returns 8 random bytes from crypto/rand, hex encoded.
+func newNodeId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// SetIdSigning signs the sids with HMAC-SHA256 of secret. A signed sid carries
+// the node id and the time it was issued, forged sids are rejected before
+// looking up the sessions. A sid older than maxAge cannot open a transport any
+// more, after the handshake or to resume the session, but the transports open
+// keep serving it. maxAge 0 never expires. Default is nil secret, sids are not
+// signed.
+func (s *Server) SetIdSigning(secret []byte, maxAge time.Duration) {
+	s.config.IdSecret = secret
+	s.config.IdMaxAge = maxAge
+}
+
+// SetIdRouter sets the handler of the requests whose signed sid was issued by
+// another node, for example a proxy to the node. Default is nil, the request is
+// served by this node.
+func (s *Server) SetIdRouter(f func(node string, w http.ResponseWriter, r *http.Request)) {
+	s.config.IdRouter = f
+}
+
+// newSid returns the id of a new session.
+func (s *Server) newSid(r *http.Request) string {
+	id := s.config.NewId(r)
+	if len(s.config.IdSecret) == 0 {
+		return id
+	}
+	payload := id + "." + base64.RawURLEncoding.EncodeToString([]byte(s.config.NodeId)) +
+		"." + strconv.FormatInt(time.Now().Unix(), 36)
+	return payload + "." + s.sidMac(payload)
+}
+
+func (s *Server) sidMac(payload string) string {
+	mac := hmac.New(sha256.New, s.config.IdSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:sidMacBytes])
+}
+
+// ParseId checks sid, and returns the node which issued it and when. The node
+// is empty and the time zero if the sids are not signed. The age of the sid is
+// not checked, see expiredId.
+func (s *Server) ParseId(sid string) (node string, issued time.Time, err error) {
+	if sid == "" || len(sid) > sidMaxLength {
+		return "", time.Time{}, InvalidId
+	}
+	for i := 0; i < len(sid); i++ {
+		if !isSidChar(sid[i]) {
+			return "", time.Time{}, InvalidId
+		}
+	}
+	if len(s.config.IdSecret) == 0 {
+		return "", time.Time{}, nil
+	}
+
+	i := strings.LastIndex(sid, ".")
+	if i < 0 {
+		return "", time.Time{}, InvalidId
+	}
+	payload, mac := sid[:i], sid[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(s.sidMac(payload))) {
+		return "", time.Time{}, InvalidId
+	}
+	parts := strings.Split(payload, ".")
+	if len(parts) < 3 {
+		return "", time.Time{}, InvalidId
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[len(parts)-2])
+	if err != nil {
+		return "", time.Time{}, InvalidId
+	}
+	unix, err := strconv.ParseInt(parts[len(parts)-1], 36, 64)
+	if err != nil {
+		return "", time.Time{}, InvalidId
+	}
+	return string(b), time.Unix(unix, 0), nil
+}
+
+// expiredId reports whether a sid issued then is older than the max age.
+func (s *Server) expiredId(issued time.Time) bool {
+	return s.config.IdMaxAge > 0 && !issued.IsZero() && time.Since(issued) > s.config.IdMaxAge
+}
+
+// isSidChar reports whether c may be in a sid, the unreserved characters of
+// urls, and the padding of base64.
+func isSidChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("-_.~=+", c) >= 0
+}
@@ -0,0 +1,75 @@
+package netio
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSid(t *testing.T) {
+	srv, _ := NewServer(nil)
+	r, _ := http.NewRequest("GET", "/socket.io/1/", nil)
+
+	sid := srv.newSid(r)
+	assert.Equal(t, 22, len(sid))
+	assert.NotEqual(t, sid, srv.newSid(r))
+	_, _, err := srv.ParseId(sid)
+	assert.Equal(t, nil, err)
+	_, _, err = srv.ParseId("../../etc")
+	assert.Equal(t, InvalidId, err)
+
+	srv.SetNodeId("node-1")
+	srv.SetIdSigning([]byte("secret"), time.Minute)
+	sid = srv.newSid(r)
+	node, issued, err := srv.ParseId(sid)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "node-1", node)
+	assert.Equal(t, true, time.Since(issued) < time.Minute)
+
+	forged := []byte(sid)
+	if forged[len(forged)-1] == 'A' {
+		forged[len(forged)-1] = 'B'
+	} else {
+		forged[len(forged)-1] = 'A'
+	}
+	_, _, err = srv.ParseId(string(forged))
+	assert.Equal(t, InvalidId, err)
+	_, _, err = srv.ParseId("plain")
+	assert.Equal(t, InvalidId, err)
+
+	payload := "x." + "bm9kZS0x" + "." + strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 36)
+	_, issued, err = srv.ParseId(payload + "." + srv.sidMac(payload))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, srv.expiredId(issued))
+	assert.Equal(t, false, srv.expiredId(time.Now()))
+	assert.NotEqual(t, newNodeId(), newNodeId())
+}
+
+func TestSidMaxAge(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.SetRecoveryTimeout(time.Minute)
+	srv.SetIdSigning([]byte("secret"), 0)
+	sid := client.handshake()
+	conn := srv.GetSessionManager().Get(sid).(*serverConn)
+	defer conn.Close()
+	assert.Equal(t, http.StatusOK, client.get("/socket.io/1/xhr-polling/"+sid).Code)
+
+	// 已打开的传输层继续服务过期的 sid
+	srv.SetIdSigning([]byte("secret"), time.Nanosecond)
+	assert.Equal(t, http.StatusOK, client.post("/socket.io/1/xhr-polling/"+sid, "2::").Code)
+
+	// 过期的 sid 不能恢复会话
+	conn.getCurrent().Close()
+	assert.Equal(t, stateDisconnected, conn.getState())
+	w := client.get("/socket.io/1/xhr-polling/" + sid)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "expired sid\n", w.Body.String())
+
+	// 也不能在握手后打开传输层
+	sid = client.handshake()
+	assert.Equal(t, http.StatusUnauthorized, client.get("/socket.io/1/xhr-polling/"+sid).Code)
+	srv.GetSessionManager().Get(sid).Close()
+}
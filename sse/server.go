@@ -0,0 +1,40 @@
+package sse
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/xjtdy888/netio/transport"
+)
+
+// NewServer returns a stream of text/event-stream messages. Packets are
+// received by POST like xhr-polling.
+func NewServer(w http.ResponseWriter, r *http.Request, callback transport.Callback) (transport.Server, error) {
+	return transport.NewStream(callback, transport.StreamFormat{
+		Header: http.Header{
+			"Content-Type":      {"text/event-stream; charset=UTF-8"},
+			"Cache-Control":     {"no-cache"},
+			"Connection":        {"keep-alive"},
+			"X-Accel-Buffering": {"no"},
+		},
+		Prelude: ":ok\n\n",
+		Encode:  encodeEvent,
+	}), nil
+}
+
+// encodeEvent frames data as one event with a single data field, data as a
+// json string the client decodes with JSON.parse. The line terminators of data
+// are escaped, so they reach the client unchanged.
+func encodeEvent(data []byte) ([]byte, error) {
+	field, err := json.Marshal(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("data: ")
+	buf.Write(field)
+	buf.WriteString("\n\n")
+	return buf.Bytes(), nil
+}
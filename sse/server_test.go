@@ -0,0 +1,104 @@
+package sse
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+	"github.com/xjtdy888/netio/transport"
+)
+
+type fakeCallback struct {
+	sender   chan []byte
+	received chan []byte
+	closed   chan transport.Server
+}
+
+func newFakeCallback() *fakeCallback {
+	return &fakeCallback{
+		sender:   make(chan []byte),
+		received: make(chan []byte, 1),
+		closed:   make(chan transport.Server, 1),
+	}
+}
+
+func (c *fakeCallback) SenderChan() chan []byte         { return c.sender }
+func (c *fakeCallback) OnRawMessage(data []byte)        { c.received <- data }
+func (c *fakeCallback) OnRawDispatchRemote(data []byte) {}
+func (c *fakeCallback) OnClose(server transport.Server) { c.closed <- server }
+func (c *fakeCallback) PollingTimeout() time.Duration   { return 0 }
+func (c *fakeCallback) Noop() []byte                    { return []byte("8::") }
+
+func TestEncodeEvent(t *testing.T) {
+	for data, event := range map[string]string{
+		"3:::":                  `data: "3:::"` + "\n\n",
+		"3:::a\nb\r\nc\rd":      `data: "3:::a\nb\r\nc\rd"` + "\n\n",
+		"3:::a\r\n\r\nb\r\rc\n": `data: "3:::a\r\n\r\nb\r\rc\n"` + "\n\n",
+	} {
+		b, _ := encodeEvent([]byte(data))
+		assert.Equal(t, event, string(b))
+	}
+}
+
+func TestStream(t *testing.T) {
+	callback := newFakeCallback()
+	s, _ := NewServer(nil, nil, callback)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream; charset=UTF-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		event := ""
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			event += line
+			if line == "\n" {
+				return event
+			}
+		}
+	}
+	assert.Equal(t, ":ok\n\n", readEvent())
+
+	// the client gets the payload back from the data field
+	payload := "3:::line 1\r\nline 2\rline 3\n"
+	callback.sender <- []byte(payload)
+	event := readEvent()
+	assert.Equal(t, true, strings.HasPrefix(event, "data: "))
+	var decoded string
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, payload, decoded)
+
+	post, err := http.Post(ts.URL, "text/plain", strings.NewReader("3:::hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Body.Close()
+	assert.Equal(t, "3:::hello", string(<-callback.received))
+
+	s.Close()
+	assert.Equal(t, s, <-callback.closed)
+	_, err = reader.ReadString('\n')
+	assert.Equal(t, io.EOF, err)
+
+	post, _ = http.Post(ts.URL, "text/plain", strings.NewReader("3:::late"))
+	assert.Equal(t, http.StatusForbidden, post.StatusCode)
+	post.Body.Close()
+}
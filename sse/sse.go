@@ -0,0 +1,11 @@
+package sse
+
+import (
+	"github.com/xjtdy888/netio/transport"
+)
+
+var Creater = transport.Creater{
+	Name:      "sse",
+	Upgrading: true,
+	Server:    NewServer,
+}
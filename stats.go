@@ -65,6 +65,14 @@ type StatsResult struct {
 	
 	PacketsSentPs float64	`json:"packets_sent_ps"`
 	PacketsRecvPs float64	`json:"packets_recv_ps"`
+	
+	ActiveClients int	`json:"active_clients"`
+	ActivePrincipals int	`json:"active_principals"`
+	Namespaces map[string]int	`json:"namespaces"`
+	RejectedConnections int64	`json:"rejected_connections"`
+	
+	PendingHandshakes int	`json:"pending_handshakes"`
+	ExpiredHandshakes int64	`json:"expired_handshakes"`
 }
 
 type  StatsCollector struct {
@@ -81,6 +89,16 @@ type  StatsCollector struct {
 	
 	PacketsSentPs *MovingAverage
 	PacketsRecvPs *MovingAverage
+	
+	// 每个客户端 IP, principal 的 session 数, 每个 namespace 的连接数
+	clients map[string]int
+	principals map[string]int
+	namespaces map[string]int
+	RejectedConnections int64
+	
+	// 握手后还没有连接传输的 session
+	PendingHandshakes int
+	ExpiredHandshakes int64
 }
 
 func NewStatsCollector() *StatsCollector{
@@ -88,6 +106,9 @@ func NewStatsCollector() *StatsCollector{
 		ConnectionsPs: NewMovingAverage(0),
 		PacketsRecvPs : NewMovingAverage(0),
 		PacketsSentPs: NewMovingAverage(0),
+		clients: make(map[string]int),
+		principals: make(map[string]int),
+		namespaces: make(map[string]int),
 	}
 	ret.Start()
 	return ret
@@ -109,6 +130,68 @@ func (s *StatsCollector) SessionClosed() {
 	s.ActiveSession -= 1
 }
 
+// acquire counts key in counts, unless it reached limit. 0 is no limit.
+func (s *StatsCollector) acquire(counts map[string]int, key string, limit int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if limit > 0 && counts[key] >= limit {
+		return false
+	}
+	counts[key] += 1
+	return true
+}
+
+func (s *StatsCollector) release(counts map[string]int, key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if counts[key] <= 1 {
+		delete(counts, key)
+	} else {
+		counts[key] -= 1
+	}
+}
+
+// ClientSessions returns the number of sessions of the client ip.
+func (s *StatsCollector) ClientSessions(ip string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.clients[ip]
+}
+
+// PrincipalSessions returns the number of sessions of the principal id.
+func (s *StatsCollector) PrincipalSessions(id string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.principals[id]
+}
+
+// NamespaceConnections returns the number of connections to the namespace endpoint.
+func (s *StatsCollector) NamespaceConnections(endpoint string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.namespaces[endpoint]
+}
+
+// ConnectionRejected counts a session or a namespace connection over the limits.
+func (s *StatsCollector) ConnectionRejected() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.RejectedConnections += 1
+}
+
+func (s *StatsCollector) SetPendingHandshakes(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.PendingHandshakes = n
+}
+
+// HandshakeExpired counts a session closed for not connecting a transport in time.
+func (s *StatsCollector) HandshakeExpired() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ExpiredHandshakes += 1
+}
+
 func (s *StatsCollector) ConnectionOpened() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -138,6 +221,10 @@ func (s *StatsCollector) OnPacketRecv(num int64) {
 func (s *StatsCollector) Dump() *StatsResult {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	namespaces := make(map[string]int, len(s.namespaces))
+	for endpoint, n := range s.namespaces {
+		namespaces[endpoint] = n
+	}
 	return &StatsResult {
 		
 		StartTime : s.StartTime,
@@ -151,6 +238,14 @@ func (s *StatsCollector) Dump() *StatsResult {
 		
 		PacketsRecvPs : s.PacketsRecvPs.lastAverage,
 		PacketsSentPs : s.PacketsSentPs.lastAverage,
+		
+		ActiveClients : len(s.clients),
+		ActivePrincipals : len(s.principals),
+		Namespaces : namespaces,
+		RejectedConnections : s.RejectedConnections,
+		
+		PendingHandshakes : s.PendingHandshakes,
+		ExpiredHandshakes : s.ExpiredHandshakes,
 	}
 }
 
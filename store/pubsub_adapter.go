@@ -0,0 +1,20 @@
+package store
+
+// PubSubAdapter adapts a PubSubStore to the netio.ClusterAdapter interface,
+// to run the bulk socket operations across the nodes:
+//
+//	server.SetAdapter(&store.PubSubAdapter{Store: store.NewNatsPubSubStore()})
+type PubSubAdapter struct {
+	Store PubSubStore
+}
+
+func (a *PubSubAdapter) Publish(subj string, data []byte) error {
+	return a.Store.Publish(subj, &Message{Data: data})
+}
+
+func (a *PubSubAdapter) Subscribe(subj string, cb func(data []byte)) error {
+	_, err := a.Store.Subscribe(subj, func(subject, reply string, msg *Message) {
+		cb(msg.Data)
+	})
+	return err
+}
@@ -66,6 +66,16 @@ func (m *SyncMap) Set(key string, value interface{}) {
 	shard.Unlock()
 }
 
+// Sets value with the given key, returns the previous value and whether it existed
+func (m *SyncMap) Swap(key string, value interface{}) (previous interface{}, loaded bool) {
+	shard := m.locate(key)
+	shard.Lock()
+	previous, loaded = shard.items[key]
+	shard.items[key] = value
+	shard.Unlock()
+	return
+}
+
 // Removes an item
 func (m *SyncMap) Delete(key string) {
 	shard := m.locate(key)
@@ -74,6 +84,16 @@ func (m *SyncMap) Delete(key string) {
 	shard.Unlock()
 }
 
+// Removes an item, returns its value and whether it existed
+func (m *SyncMap) Take(key string) (value interface{}, ok bool) {
+	shard := m.locate(key)
+	shard.Lock()
+	value, ok = shard.items[key]
+	delete(shard.items, key)
+	shard.Unlock()
+	return
+}
+
 // Pop delete and return a random item in the cache
 func (m *SyncMap) Pop() (string, interface{}) {
 	if m.Size() == 0 {
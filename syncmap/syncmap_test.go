@@ -82,6 +82,36 @@ func Test_Delete(t *testing.T) {
 	}
 }
 
+func Test_Swap(t *testing.T) {
+	m := New()
+	v, loaded := m.Swap("one", 1)
+	if loaded || v != nil {
+		t.Error("Swap should not load a missing key")
+	}
+	v, loaded = m.Swap("one", 2)
+	if !loaded || v.(int) != 1 {
+		t.Error("Swap should return the previous value")
+	}
+	if v, _ := m.Get("one"); v.(int) != 2 {
+		t.Error("Swap should set the new value")
+	}
+}
+
+func Test_Take(t *testing.T) {
+	m := New()
+	m.Set("one", 1)
+	v, ok := m.Take("one")
+	if !ok || v.(int) != 1 {
+		t.Error("Take should return the value of existing key")
+	}
+	if m.Has("one") {
+		t.Error("Take should remove the key")
+	}
+	if _, ok := m.Take("one"); ok {
+		t.Error("Take should return false for missing key")
+	}
+}
+
 func Test_Size(t *testing.T) {
 	m := New()
 	for i := 0; i < 42; i++ {
@@ -0,0 +1,184 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	log "github.com/cihub/seelog"
+)
+
+type streamState int
+
+const (
+	streamNormal streamState = iota
+	streamClosing
+	streamClosed
+)
+
+// StreamFormat is how a Stream writes the payloads to its response.
+type StreamFormat struct {
+	// Header is set on the response.
+	Header http.Header
+	// Prelude is written before the first payload.
+	Prelude string
+	// Encode frames one payload.
+	Encode func(data []byte) ([]byte, error)
+}
+
+// Stream writes the payloads to the client over one never ending response,
+// and receives packets by POST like xhr-polling. The htmlfile and sse
+// transports are streams.
+type Stream struct {
+	callback    Callback
+	format      StreamFormat
+	state       streamState
+	streaming   bool
+	stateLocker sync.Mutex
+	closeOnce   sync.Once
+	closeChan   chan bool
+}
+
+func NewStream(callback Callback, format StreamFormat) *Stream {
+	return &Stream{
+		callback:  callback,
+		format:    format,
+		state:     streamNormal,
+		closeChan: make(chan bool),
+	}
+}
+
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		s.stream(w, r)
+	case "POST":
+		s.post(w, r)
+	}
+}
+
+func (s *Stream) Close() error {
+	s.stateLocker.Lock()
+	if s.state != streamNormal {
+		s.stateLocker.Unlock()
+		return nil
+	}
+	s.state = streamClosing
+	streaming := s.streaming
+	s.stateLocker.Unlock()
+
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+	})
+	if !streaming {
+		s.setState(streamClosed)
+		s.callback.OnClose(s)
+	}
+	return nil
+}
+
+func (s *Stream) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	s.stateLocker.Lock()
+	if s.state != streamNormal {
+		s.stateLocker.Unlock()
+		http.Error(w, "closed", http.StatusForbidden)
+		return
+	}
+	if s.streaming {
+		s.stateLocker.Unlock()
+		http.Error(w, "overlay stream", http.StatusBadRequest)
+		return
+	}
+	s.streaming = true
+	s.stateLocker.Unlock()
+
+	defer func() {
+		s.stateLocker.Lock()
+		s.streaming = false
+		s.state = streamClosed
+		s.stateLocker.Unlock()
+		s.callback.OnClose(s)
+	}()
+
+	for k, v := range s.format.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, s.format.Prelude)
+	flusher.Flush()
+
+	// SenderChan blocks while the transport is probed as an upgrade target,
+	// so wait for it next to the close notifications.
+	ready := make(chan chan []byte, 1)
+	go func() {
+		ready <- s.callback.SenderChan()
+	}()
+
+	var senderChan chan []byte
+	for {
+		select {
+		case senderChan = <-ready:
+			ready = nil
+		case data, ok := <-senderChan:
+			if !ok {
+				return
+			}
+			s.callback.OnRawDispatchRemote(data)
+			frame, err := s.format.Encode(data)
+			if err != nil {
+				log.Errorf("[%s] encode error [%s]", r.URL.Path, err)
+				return
+			}
+			if _, err := w.Write(frame); err != nil {
+				log.Debugf("[%s] stream write error %s", r.URL.Path, err)
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			log.Debugf("[%s] client gone", r.URL.Path)
+			return
+		case <-s.closeChan:
+			return
+		}
+	}
+}
+
+func (s *Stream) post(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	if s.getState() != streamNormal {
+		http.Error(w, "closed", http.StatusForbidden)
+		return
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Errorf("read post body error %s %s", err, r.URL.Path)
+		return
+	}
+	//IE XDomainRequest support
+	if bytes.HasPrefix(data, []byte("data=")) {
+		data = data[5:]
+	}
+
+	s.callback.OnRawMessage(data)
+}
+
+func (s *Stream) setState(st streamState) {
+	s.stateLocker.Lock()
+	defer s.stateLocker.Unlock()
+	s.state = st
+}
+
+func (s *Stream) getState() streamState {
+	s.stateLocker.Lock()
+	defer s.stateLocker.Unlock()
+	return s.state
+}
@@ -2,7 +2,7 @@ package transport
 
 import (
 	"net/http"
-
+	"time"
 )
 
 type Callback interface {
@@ -11,6 +11,33 @@ type Callback interface {
 	OnRawMessage(data []byte)
 	OnRawDispatchRemote(data []byte)
 	OnClose(server Server)
+
+	// PollingTimeout is how long a polling request waits for data, before it
+	// is answered with a noop. 0 waits until there is data.
+	PollingTimeout() time.Duration
+	// Noop returns the payload of a noop packet.
+	Noop() []byte
+}
+
+// OriginChecker is implemented by the callbacks checking the origin of the
+// websocket upgrades.
+type OriginChecker interface {
+	CheckOrigin(r *http.Request) bool
+}
+
+// Message is a payload of the send queue with the options of its packets.
+type Message struct {
+	Data []byte
+	// Compress asks for the payload to be compressed, if the transport and
+	// the client support it.
+	Compress bool
+}
+
+// MessageSender is implemented by the callbacks queuing Messages. The
+// transports applying per-message options read MessageChan instead of
+// SenderChan, a payload is received from either of them.
+type MessageSender interface {
+	MessageChan() chan Message
 }
 
 type Creater struct {
@@ -0,0 +1,40 @@
+package netio
+
+import (
+	"sync"
+
+	"github.com/xjtdy888/netio/htmlfile"
+	"github.com/xjtdy888/netio/polling"
+	"github.com/xjtdy888/netio/sse"
+	"github.com/xjtdy888/netio/transport"
+	"github.com/xjtdy888/netio/websocket"
+)
+
+var registeredTransports = struct {
+	sync.RWMutex
+	creaters transportCreaters
+}{creaters: make(transportCreaters)}
+
+// RegisterTransport makes a transport available to NewServer under creater.Name.
+// Registering a name again replaces the previous creater.
+func RegisterTransport(creater transport.Creater) {
+	registeredTransports.Lock()
+	defer registeredTransports.Unlock()
+	registeredTransports.creaters[creater.Name] = creater
+}
+
+func lookupTransport(name string) (transport.Creater, bool) {
+	registeredTransports.RLock()
+	defer registeredTransports.RUnlock()
+	creater, ok := registeredTransports.creaters[name]
+	return creater, ok
+}
+
+func init() {
+	RegisterTransport(polling.XHRCreater)
+	RegisterTransport(polling.JSONPCreater)
+	RegisterTransport(websocket.Creater)
+	RegisterTransport(websocket.FlashCreater)
+	RegisterTransport(htmlfile.Creater)
+	RegisterTransport(sse.Creater)
+}
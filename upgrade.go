@@ -0,0 +1,221 @@
+package netio
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/xjtdy888/netio/transport"
+)
+
+// upgradeCallback is handed to a transport while it is probed as the upgrade
+// target. Until the upgrade is switched over the transport gets no outbound
+// data, so the current transport keeps serving the client.
+type upgradeCallback struct {
+	*serverConn
+	ready     chan struct{}
+	active    chan struct{}
+	aborted   chan struct{}
+	abortOnce sync.Once
+}
+
+func newUpgradeCallback(c *serverConn) *upgradeCallback {
+	return &upgradeCallback{
+		serverConn: c,
+		ready:      make(chan struct{}),
+		active:     make(chan struct{}),
+		aborted:    make(chan struct{}),
+	}
+}
+
+// SenderChan blocks until the upgrade is switched over or aborted. An aborted
+// probe gets a nil channel and never receives data.
+func (u *upgradeCallback) SenderChan() chan []byte {
+	select {
+	case <-u.active:
+		return u.serverConn.SenderChan()
+	case <-u.aborted:
+		return nil
+	}
+}
+
+// MessageChan blocks like SenderChan.
+func (u *upgradeCallback) MessageChan() chan transport.Message {
+	select {
+	case <-u.active:
+		return u.serverConn.MessageChan()
+	case <-u.aborted:
+		return nil
+	}
+}
+
+// OnRawMessage lets the protocol verify the new transport, then switches the
+// session over to it. For socket.io 0.9 the first message received is enough.
+func (u *upgradeCallback) OnRawMessage(data []byte) {
+	// 传输层在 upgrade 登记它之前就可能收到消息
+	select {
+	case <-u.ready:
+	case <-u.aborted:
+		return
+	}
+	if !u.pending() {
+		// switched over, the transport is the current one
+		u.serverConn.OnRawMessage(data)
+		return
+	}
+	_, t := u.serverConn.getUpgrading()
+	upgrade, consumed := u.serverConn.proto.probe(u.serverConn, t, data)
+	if upgrade {
+		// 等待旧传输层排空时不阻塞新传输层的读取
+		go u.serverConn.upgraded(u)
+	}
+	if !consumed {
+		u.serverConn.OnRawMessage(data)
+	}
+}
+
+// pending reports whether u is the probe of the upgrade in progress.
+func (u *upgradeCallback) pending() bool {
+	c := u.serverConn
+	c.transportLocker.RLock()
+	probe := c.probe
+	c.transportLocker.RUnlock()
+	return probe == u && c.getState() == stateUpgrading
+}
+
+func (u *upgradeCallback) abort() {
+	u.abortOnce.Do(func() {
+		close(u.aborted)
+	})
+}
+
+func (c *serverConn) getUpgrading() (string, transport.Server) {
+	c.transportLocker.RLock()
+	defer c.transportLocker.RUnlock()
+
+	return c.upgradingName, c.upgrading
+}
+
+// upgrade starts probing transportName as the new transport of the session.
+func (c *serverConn) upgrade(transportName string, w http.ResponseWriter, r *http.Request) {
+	creater := c.callback.transports().Get(transportName)
+	if creater.Name == "" {
+		http.Error(w, fmt.Sprintf("invalid transport %s", transportName), http.StatusBadRequest)
+		return
+	}
+	if !c.callback.configure().AllowUpgrades || !creater.Upgrading {
+		http.Error(w, fmt.Sprintf("upgrade to %s not allowed", transportName), http.StatusBadRequest)
+		return
+	}
+	if c.getState() != stateNormal {
+		http.Error(w, "upgrade not allowed", http.StatusBadRequest)
+		return
+	}
+
+	probe := newUpgradeCallback(c)
+	u, err := creater.Server(w, r, probe)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.transportLocker.Lock()
+	if c.upgrading != nil {
+		c.transportLocker.Unlock()
+		probe.abort()
+		u.Close()
+		http.Error(w, "upgrade in progress", http.StatusBadRequest)
+		return
+	}
+	c.upgradingName = creater.Name
+	c.upgrading = u
+	c.probe = probe
+	c.transportLocker.Unlock()
+	c.setState(stateUpgrading)
+	close(probe.ready)
+
+	log.Debugf("[%s] probing upgrade %s -> %s", c.Id(), c.getCurrentName(), creater.Name)
+	go func() {
+		select {
+		case <-probe.active:
+		case <-probe.aborted:
+		case <-time.After(c.pingTimeout):
+			log.Debugf("[%s] upgrade to %s timed out", c.Id(), creater.Name)
+			c.abortUpgrade(u)
+		}
+	}()
+
+	u.ServeHTTP(w, r)
+}
+
+// upgraded switches the session over to the probed transport. The old
+// transport is closed first and its in-flight response is allowed to finish,
+// so nothing it already took from the queue is lost.
+func (c *serverConn) upgraded(probe *upgradeCallback) {
+	c.transportLocker.Lock()
+	if c.probe != probe {
+		c.transportLocker.Unlock()
+		return
+	}
+	old, oldName := c.current, c.currentName
+	c.current, c.currentName = c.upgrading, c.upgradingName
+	c.upgrading, c.upgradingName, c.probe = nil, "", nil
+	drained := make(chan struct{})
+	c.draining, c.drained = old, drained
+	newName := c.currentName
+	c.transportLocker.Unlock()
+
+	if old != nil {
+		old.Close()
+		select {
+		case <-drained:
+		case <-time.After(c.pingTimeout):
+			log.Warnf("[%s] %s did not drain in time", c.Id(), oldName)
+		}
+	}
+
+	c.transportLocker.Lock()
+	c.draining, c.drained = nil, nil
+	c.transportLocker.Unlock()
+
+	close(probe.active)
+	if c.getState() == stateUpgrading {
+		c.setState(stateNormal)
+	}
+	log.Debugf("[%s] upgraded %s -> %s", c.Id(), oldName, newName)
+	c.defaultNS.emit("upgrade", c.defaultNS, nil, oldName, newName)
+}
+
+// abortUpgrade drops server if it is the transport being probed.
+func (c *serverConn) abortUpgrade(server transport.Server) bool {
+	c.transportLocker.Lock()
+	if server == nil || c.upgrading != server {
+		c.transportLocker.Unlock()
+		return false
+	}
+	probe := c.probe
+	c.upgrading, c.upgradingName, c.probe = nil, "", nil
+	c.transportLocker.Unlock()
+
+	probe.abort()
+	if c.getState() == stateUpgrading {
+		c.setState(stateNormal)
+	}
+	server.Close()
+	return true
+}
+
+// finishDrain reports the old transport of an upgrade as drained.
+func (c *serverConn) finishDrain(server transport.Server) bool {
+	c.transportLocker.Lock()
+	defer c.transportLocker.Unlock()
+
+	if server == nil || c.draining != server {
+		return false
+	}
+	close(c.drained)
+	c.draining = nil
+	return true
+}
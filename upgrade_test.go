@@ -0,0 +1,108 @@
+package netio
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+	"github.com/gorilla/websocket"
+)
+
+func TestUpgrade(t *testing.T) {
+	srv, client := newTestServer(t)
+	upgraded := make(chan string, 1)
+	srv.On("upgrade", func(ns *NameSpace, from, to string) {
+		upgraded <- from + " -> " + to
+	})
+	url := client.listen()
+
+	sid := client.handshake()
+	conn := srv.GetSessionManager().Get(sid).(*serverConn)
+	defer conn.Close()
+	client.get("/socket.io/1/xhr-polling/" + sid)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/socket.io/1/websocket/"+sid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// socket.io 0.9 收到第一条消息即确认升级
+	ws.WriteMessage(websocket.TextMessage, []byte("2::"))
+	select {
+	case event := <-upgraded:
+		assert.Equal(t, "xhr-polling -> websocket", event)
+	case <-time.After(time.Second):
+		t.Fatal("not upgraded")
+	}
+	assert.Equal(t, "websocket", conn.getCurrentName())
+	assert.Equal(t, stateNormal, conn.getState())
+
+	conn.Of("").Emit("news", "hello")
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := ws.ReadMessage()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(message), `"args":["hello"]`))
+}
+
+func TestUpgradeProbe(t *testing.T) {
+	srv, client := newTestServer(t)
+	upgraded := make(chan string, 1)
+	srv.On("upgrade", func(ns *NameSpace, from, to string) {
+		upgraded <- to
+	})
+	srv.On("ping", func(ns *NameSpace) {
+		ns.Emit("news", "after")
+	})
+	url := client.listen()
+	get := func(query string) string {
+		return client.get("/socket.io/?EIO=4&" + query).Body.String()
+	}
+
+	open := eioOpenData{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(get("transport=polling"), "0")), &open); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"websocket"}, open.Upgrades)
+	conn := srv.GetSessionManager().Get(open.Sid).(*serverConn)
+	defer conn.Close()
+	client.post("/socket.io/?EIO=4&transport=polling&sid="+open.Sid, "40")
+	assert.Equal(t, true, strings.HasPrefix(get("transport=polling&sid="+open.Sid), "40"))
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/socket.io/?EIO=4&transport=websocket&sid="+open.Sid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+
+	ws.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	_, message, err := ws.ReadMessage()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "3probe", string(message))
+	assert.Equal(t, "xhr-polling", conn.getCurrentName())
+	// 旧传输层收到 noop 结束等待
+	assert.Equal(t, "6", get("transport=polling&sid="+open.Sid))
+
+	ws.WriteMessage(websocket.TextMessage, []byte("5"))
+	select {
+	case to := <-upgraded:
+		assert.Equal(t, "websocket", to)
+	case <-time.After(time.Second):
+		t.Fatal("not upgraded")
+	}
+
+	conn.Of("").Emit("news", "hello")
+	_, message, err = ws.ReadMessage()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `42["news","hello"]`, string(message))
+
+	// 切换之后 probe 不再被当作升级处理，不会再回 noop
+	ws.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	ws.WriteMessage(websocket.TextMessage, []byte(`42["ping"]`))
+	_, message, err = ws.ReadMessage()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `42["news","after"]`, string(message))
+}
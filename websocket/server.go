@@ -26,13 +26,28 @@ type Server struct {
 	stateLocker sync.Mutex
 	broadOnce sync.Once
 	closeChan   chan bool
+	writeLocker sync.Mutex
 }
 
 func NewServer(w http.ResponseWriter, r *http.Request, callback transport.Callback) (transport.Server, error) {
-	conn, err := websocket.Upgrade(w, r, nil, 10240, 10240)
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  10240,
+		WriteBufferSize: 10240,
+		CheckOrigin:     func(r *http.Request) bool { return true },
+		// permessage-deflate is negotiated, and used for the messages asking
+		// for it, see transport.Message
+		EnableCompression: true,
+		// 错误由调用者返回给客户端
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {},
+	}
+	if checker, ok := callback.(transport.OriginChecker); ok {
+		upgrader.CheckOrigin = checker.CheckOrigin
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return nil, err
 	}
+	conn.EnableWriteCompression(false)
 
 	ret := &Server{
 		callback:  callback,
@@ -50,6 +65,25 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusBadRequest)
 }
 
+// Write sends p as one text frame, bypassing the send queue. It is used to
+// answer upgrade probes before the transport gets the queue.
+func (s *Server) Write(p []byte) (int, error) {
+	if err := s.writeMessage(transport.Message{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeMessage sends msg as one text frame, compressed if it asks for it and
+// the client negotiated permessage-deflate.
+func (s *Server) writeMessage(msg transport.Message) error {
+	s.writeLocker.Lock()
+	defer s.writeLocker.Unlock()
+
+	s.conn.EnableWriteCompression(msg.Compress)
+	return s.conn.WriteMessage(websocket.TextMessage, msg.Data)
+}
+
 func (s *Server) Close() error {
 	if s.getState() != stateNormal {
 		return nil
@@ -81,25 +115,32 @@ func (s *Server) getState() state {
 
 func (s *Server) writer(closeChan chan bool) {
 	
-	senderChan := s.callback.SenderChan()
+	var senderChan chan []byte
+	var messageChan chan transport.Message
+	if sender, ok := s.callback.(transport.MessageSender); ok {
+		messageChan = sender.MessageChan()
+	} else {
+		senderChan = s.callback.SenderChan()
+	}
 	loop:
 	for {
-		var data []byte
+		var msg transport.Message
 		var ok bool
 		select {
-		case data, ok = <-senderChan:
-			if ok {
-				s.callback.OnRawDispatchRemote(data)
-				err := s.conn.WriteMessage(websocket.TextMessage, data)
-				if err != nil {
-					log.Errorf("%s", err)
-					s.Close()
-					break loop
-				}
-			}
+		case msg.Data, ok = <-senderChan:
+		case msg, ok = <-messageChan:
 		case <-closeChan :
 			break loop 
 		}
+		if ok {
+			s.callback.OnRawDispatchRemote(msg.Data)
+			err := s.writeMessage(msg)
+			if err != nil {
+				log.Errorf("%s", err)
+				s.Close()
+				break loop
+			}
+		}
 	}
 	log.Infof("[%s] websocket writer exiting", s.conn.RemoteAddr().String())
 	s.conn.Close()
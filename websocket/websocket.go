@@ -9,3 +9,12 @@ var Creater = transport.Creater{
 	Upgrading: true,
 	Server:    NewServer,
 }
+
+// FlashCreater serves the flashsocket transport of socket.io 0.9 clients. It
+// speaks the websocket protocol; the flash socket policy file has to be served
+// separately.
+var FlashCreater = transport.Creater{
+	Name:      "flashsocket",
+	Upgrading: false,
+	Server:    NewServer,
+}